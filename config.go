@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"qvl.io/ghbackup/ghbackup"
+)
+
+// Format of the file passed with -config.
+// Example:
+//
+//	dir: /backup/github
+//	secret_file: /etc/ghbackup/token
+//	workers: 5
+//	retries: [10s, 1m]
+//	accounts:
+//	  - name: qvl
+//	  - name: other-org
+//	    secret_file: /etc/ghbackup/other-org-token
+//	filter:
+//	  exclude: ["*-sandbox"]
+//	  skip_forks: true
+type fileConfig struct {
+	Dir           string        `yaml:"dir"`
+	Provider      string        `yaml:"provider"`
+	API           string        `yaml:"api"`
+	CAFile        string        `yaml:"ca_file"`
+	Workers       int           `yaml:"workers"`
+	Secret        string        `yaml:"secret"`
+	SecretFile    string        `yaml:"secret_file"`
+	Accounts      []fileAccount `yaml:"accounts"`
+	Retries       []string      `yaml:"retries"`
+	GraphQL       bool          `yaml:"graphql"`
+	Protocol      string        `yaml:"protocol"`
+	SSHKey        string        `yaml:"ssh_key"`
+	SSHKnownHosts string        `yaml:"ssh_known_hosts"`
+	App           *fileApp      `yaml:"app"`
+	Wikis         bool          `yaml:"wikis"`
+	Issues        bool          `yaml:"issues"`
+	Pulls         bool          `yaml:"pulls"`
+	Releases      bool          `yaml:"releases"`
+	LFS           bool          `yaml:"lfs"`
+	Gists         bool          `yaml:"gists"`
+	Starred       bool          `yaml:"starred"`
+	Orphans       string        `yaml:"orphans"`
+	OrphanDays    int           `yaml:"orphan_days"`
+	Force         bool          `yaml:"force"`
+	Filter        fileFilter    `yaml:"filter"`
+	StarredFilter fileFilter    `yaml:"starred_filter"`
+}
+
+type fileApp struct {
+	ID             int64  `yaml:"id"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	InstallationID int64  `yaml:"installation_id"`
+}
+
+type fileAccount struct {
+	Name       string `yaml:"name"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+	Provider   string `yaml:"provider"`
+	API        string `yaml:"api"`
+}
+
+type fileFilter struct {
+	Include      []string `yaml:"include"`
+	Exclude      []string `yaml:"exclude"`
+	SkipForks    bool     `yaml:"skip_forks"`
+	SkipArchived bool     `yaml:"skip_archived"`
+	SkipDisabled bool     `yaml:"skip_disabled"`
+	OnlyPrivate  bool     `yaml:"only_private"`
+	Topics       []string `yaml:"topics"`
+	Languages    []string `yaml:"languages"`
+	MaxSize      int      `yaml:"max_size"`
+}
+
+// Read and validate a config file.
+// Unknown keys are reported as errors.
+func readConfig(file string) (ghbackup.Config, error) {
+	var config ghbackup.Config
+	f, err := os.Open(file)
+	if err != nil {
+		return config, fmt.Errorf("cannot open config file: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && err != io.EOF {
+		return config, fmt.Errorf("invalid config file %s: %v", file, err)
+	}
+
+	config, err = fc.toConfig()
+	if err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", file, err)
+	}
+	return config, nil
+}
+
+func (fc fileConfig) toConfig() (ghbackup.Config, error) {
+	config := ghbackup.Config{
+		Dir:           fc.Dir,
+		API:           fc.API,
+		CAFile:        fc.CAFile,
+		Workers:       fc.Workers,
+		GraphQL:       fc.GraphQL,
+		Protocol:      fc.Protocol,
+		SSHKey:        fc.SSHKey,
+		SSHKnownHosts: fc.SSHKnownHosts,
+		Wikis:         fc.Wikis,
+		Issues:        fc.Issues,
+		Pulls:         fc.Pulls,
+		Releases:      fc.Releases,
+		LFS:           fc.LFS,
+		Gists:         fc.Gists,
+		Starred:       fc.Starred,
+		Orphans:       fc.Orphans,
+		OrphanDays:    fc.OrphanDays,
+		Force:         fc.Force,
+		Filter:        fc.Filter.toFilter(),
+		StarredFilter: fc.StarredFilter.toFilter(),
+	}
+	if fc.Workers < 0 {
+		return config, fmt.Errorf("workers must not be negative")
+	}
+	if fc.Filter.MaxSize < 0 {
+		return config, fmt.Errorf("filter.max_size must not be negative")
+	}
+	if fc.OrphanDays < 0 {
+		return config, fmt.Errorf("orphan_days must not be negative")
+	}
+	if fc.StarredFilter.MaxSize < 0 {
+		return config, fmt.Errorf("starred_filter.max_size must not be negative")
+	}
+
+	if fc.Provider != "" {
+		p, err := ghbackup.ParseProvider(fc.Provider)
+		if err != nil {
+			return config, err
+		}
+		config.Provider = p
+	}
+
+	secret, err := readSecret(fc.Secret, fc.SecretFile)
+	if err != nil {
+		return config, err
+	}
+	config.Secret = secret
+
+	for i, a := range fc.Accounts {
+		if a.Name == "" {
+			return config, fmt.Errorf("accounts[%d]: name is required", i)
+		}
+		secret, err := readSecret(a.Secret, a.SecretFile)
+		if err != nil {
+			return config, fmt.Errorf("accounts[%d]: %v", i, err)
+		}
+		account := ghbackup.Account{Name: a.Name, Secret: secret, API: a.API}
+		if a.Provider != "" {
+			if account.Provider, err = ghbackup.ParseProvider(a.Provider); err != nil {
+				return config, fmt.Errorf("accounts[%d]: %v", i, err)
+			}
+		}
+		config.Accounts = append(config.Accounts, account)
+	}
+
+	if fc.App != nil {
+		app, err := readApp(fc.App.ID, fc.App.PrivateKeyFile, fc.App.InstallationID)
+		if err != nil {
+			return config, fmt.Errorf("app: %v", err)
+		}
+		config.App = app
+	}
+
+	if fc.Retries != nil {
+		config.Retries = []time.Duration{}
+		for _, r := range fc.Retries {
+			d, err := time.ParseDuration(r)
+			if err != nil {
+				return config, fmt.Errorf("retries: %v", err)
+			}
+			config.Retries = append(config.Retries, d)
+		}
+	}
+
+	return config, nil
+}
+
+func (ff fileFilter) toFilter() ghbackup.Filter {
+	return ghbackup.Filter{
+		Include:      ff.Include,
+		Exclude:      ff.Exclude,
+		SkipForks:    ff.SkipForks,
+		SkipArchived: ff.SkipArchived,
+		SkipDisabled: ff.SkipDisabled,
+		OnlyPrivate:  ff.OnlyPrivate,
+		Topics:       ff.Topics,
+		Languages:    ff.Languages,
+		MaxSize:      ff.MaxSize,
+	}
+}
+
+// Get a secret either directly or from a file.
+func readSecret(secret, file string) (string, error) {
+	if file == "" {
+		return secret, nil
+	}
+	if secret != "" {
+		return "", fmt.Errorf("only one of secret and secret_file can be set")
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret file: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Get a GitHub App with the private key read from a file.
+func readApp(id int64, keyFile string, installationID int64) (*ghbackup.App, error) {
+	if id == 0 || keyFile == "" {
+		return nil, fmt.Errorf("app ID and private key file are required")
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read private key: %v", err)
+	}
+	return &ghbackup.App{ID: id, PrivateKey: key, InstallationID: installationID}, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"qvl.io/ghbackup/ghbackup"
+)
+
+func Test_readConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	secretFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(secretFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		yaml    string
+		want    ghbackup.Config
+		wantErr string
+	}{
+		{
+			name: "empty",
+			yaml: "",
+			want: ghbackup.Config{},
+		},
+		{
+			name: "values",
+			yaml: `
+dir: /backup
+secret: token
+workers: 3
+orphans: delete
+orphan_days: 7
+force: true
+accounts:
+  - name: qvl
+  - name: other
+    secret_file: ` + secretFile + `
+filter:
+  exclude: ["*-sandbox"]
+  skip_forks: true
+`,
+			want: ghbackup.Config{
+				Dir:        "/backup",
+				Secret:     "token",
+				Workers:    3,
+				Orphans:    "delete",
+				OrphanDays: 7,
+				Force:      true,
+				Accounts:   []ghbackup.Account{{Name: "qvl"}, {Name: "other", Secret: "file-token"}},
+				Filter:     ghbackup.Filter{Exclude: []string{"*-sandbox"}, SkipForks: true},
+			},
+		},
+		{
+			name: "retries",
+			yaml: "retries: [10s, 1m]",
+			want: ghbackup.Config{Retries: []time.Duration{10 * time.Second, time.Minute}},
+		},
+		{
+			name: "no retries",
+			yaml: "retries: []",
+			want: ghbackup.Config{Retries: []time.Duration{}},
+		},
+		{
+			name:    "invalid retry",
+			yaml:    "retries: [often]",
+			wantErr: "retries",
+		},
+		{
+			name:    "unknown key",
+			yaml:    "secrets: token",
+			wantErr: "secrets",
+		},
+		{
+			name:    "secret and secret file",
+			yaml:    "secret: token\nsecret_file: " + secretFile,
+			wantErr: "only one of secret and secret_file",
+		},
+		{
+			name:    "account without name",
+			yaml:    "accounts: [{secret: token}]",
+			wantErr: "accounts[0]: name is required",
+		},
+		{
+			name:    "negative workers",
+			yaml:    "workers: -1",
+			wantErr: "workers must not be negative",
+		},
+		{
+			name:    "negative max size",
+			yaml:    "filter: {max_size: -1}",
+			wantErr: "filter.max_size must not be negative",
+		},
+		{
+			name:    "negative orphan days",
+			yaml:    "orphan_days: -1",
+			wantErr: "orphan_days must not be negative",
+		},
+		{
+			name:    "negative starred max size",
+			yaml:    "starred_filter: {max_size: -1}",
+			wantErr: "starred_filter.max_size must not be negative",
+		},
+		{
+			name:    "unknown provider",
+			yaml:    "provider: bitbucket",
+			wantErr: "unsupported provider",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, "config.yml")
+			if err := ioutil.WriteFile(file, []byte(tt.yaml), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readConfig(file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q; got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_flags_apply(t *testing.T) {
+	file := ghbackup.Config{
+		Secret:   "file-token",
+		Accounts: []ghbackup.Account{{Name: "qvl", Secret: "account-token"}},
+		Workers:  3,
+		Orphans:  "archive",
+		Filter:   ghbackup.Filter{Exclude: []string{"*-sandbox"}, SkipForks: true},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want ghbackup.Config
+	}{
+		{
+			name: "no flags",
+			want: file,
+		},
+		{
+			name: "flags override file",
+			args: []string{"-secret", "flag-token", "-orphans", "keep", "-skip-forks=false", "-exclude", "old-*"},
+			want: ghbackup.Config{
+				Secret:   "flag-token",
+				Accounts: []ghbackup.Account{{Name: "qvl", Secret: "account-token"}},
+				Workers:  3,
+				Orphans:  "keep",
+				Filter:   ghbackup.Filter{Exclude: []string{"old-*"}},
+			},
+		},
+		{
+			name: "accounts replace accounts of file",
+			args: []string{"-account", "jorinvo,qvl"},
+			want: ghbackup.Config{
+				Secret:   "file-token",
+				Accounts: []ghbackup.Account{{Name: "jorinvo"}, {Name: "qvl"}},
+				Workers:  3,
+				Orphans:  "archive",
+				Filter:   ghbackup.Filter{Exclude: []string{"*-sandbox"}, SkipForks: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("ghbackup", flag.ContinueOnError)
+			f := defineFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			config := file
+			if err := f.apply(&config, fs); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("config = %+v, want %+v", config, tt.want)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("ghbackup", flag.ContinueOnError)
+	f := defineFlags(fs)
+	if err := fs.Parse([]string{"-provider", "bitbucket"}); err != nil {
+		t.Fatal(err)
+	}
+	config := file
+	if err := f.apply(&config, fs); err == nil {
+		t.Errorf("expected unknown provider to be rejected")
+	}
+}
@@ -0,0 +1,207 @@
+package ghbackup
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Provides credentials for the GitHub API and git.
+type authenticator interface {
+	// Add credentials to a request to the GitHub API.
+	authorize(req *http.Request) error
+	// Get the secret used by git over HTTPS.
+	// Returns an empty string if no authentication is used.
+	gitSecret(ctx context.Context) (string, error)
+}
+
+// Authentication with a static secret like a personal access token.
+type secretAuth struct {
+	account string
+	secret  string
+}
+
+func (a secretAuth) authorize(req *http.Request) error {
+	if a.secret != "" {
+		// For token authentication `account` will be ignored
+		req.SetBasicAuth(a.account, a.secret)
+	}
+	return nil
+}
+
+func (a secretAuth) gitSecret(context.Context) (string, error) {
+	return a.secret, nil
+}
+
+// A GitHub App with a parsed private key.
+type appAuth struct {
+	id  int64
+	key *rsa.PrivateKey
+}
+
+// Refresh installation tokens if they expire within this duration.
+// Leaves enough time for long running git commands.
+const tokenRefreshMargin = 10 * time.Minute
+
+func newAppAuth(app App) (*appAuth, error) {
+	block, _ := pem.Decode(app.PrivateKey)
+	if block == nil {
+		return nil, errors.New("cannot decode private key of GitHub App: no PEM data found")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("cannot parse private key of GitHub App: %v", err)
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("private key of GitHub App is not an RSA key")
+		}
+	}
+	return &appAuth{id: app.ID, key: key}, nil
+}
+
+// Create a JSON Web Token to authenticate as the app itself.
+// See https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (a *appAuth) jwt(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}{
+		// Allow for clock drift
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+		Issuer:    strconv.FormatInt(a.id, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("cannot sign JWT: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Add the app's JWT to a request.
+func (a *appAuth) authorize(req *http.Request) error {
+	token, err := a.jwt(time.Now())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Authentication as an installation of a GitHub App.
+// The installation token is refreshed before it expires.
+type installationAuth struct {
+	app  *appAuth
+	id   int64
+	api  string
+	doer Doer
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (a *installationAuth) authorize(req *http.Request) error {
+	token, err := a.currentToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	return nil
+}
+
+func (a *installationAuth) gitSecret(ctx context.Context) (string, error) {
+	return a.currentToken(ctx)
+}
+
+// Get a valid installation token.
+// A new one is requested if there is none yet or if it expires soon.
+func (a *installationAuth) currentToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Until(a.expires) > tokenRefreshMargin {
+		return a.token, nil
+	}
+
+	u := fmt.Sprintf("%s/app/installations/%d/access_tokens", a.api, a.id)
+	req, err := http.NewRequestWithContext(ctx, "POST", u, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot create request: %v", err)
+	}
+	if err := a.app.authorize(req); err != nil {
+		return "", err
+	}
+	res, err := a.doer.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot get installation token: %v", err)
+	}
+
+	var t struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := decodeResponse(res, &t); err != nil {
+		return "", err
+	}
+	a.token = t.Token
+	a.expires = t.ExpiresAt
+	return a.token, nil
+}
+
+// An installation of a GitHub App on a user or organization account.
+type installation struct {
+	ID      int64 `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+}
+
+// Get all installations of the app.
+// Follow all "next" links.
+func getInstallations(ctx context.Context, app *appAuth, api string, doer Doer) ([]installation, error) {
+	var all []installation
+	currentURL := api + "/app/installations?per_page=100"
+	for currentURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", currentURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create request: %v", err)
+		}
+		if err := app.authorize(req); err != nil {
+			return nil, err
+		}
+		res, err := doer.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get installations: %v", err)
+		}
+		var page []installation
+		err = decodeResponse(res, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		currentURL = getNextURL(res.Header)
+	}
+	return all, nil
+}
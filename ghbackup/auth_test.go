@@ -0,0 +1,144 @@
+package ghbackup
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Doer answering requests with a function.
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func jsonResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+func testApp(t *testing.T) (*rsa.PrivateKey, App) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, App{ID: 42, PrivateKey: pemKey}
+}
+
+func Test_appAuth_jwt(t *testing.T) {
+	key, app := testApp(t)
+	a, err := newAppAuth(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	token, err := a.jwt(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected JWT with 3 parts; got %s", token)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c map[string]interface{}
+	if err := json.Unmarshal(claims, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c["iss"] != "42" || c["iat"] != float64(1599999940) || c["exp"] != float64(1600000540) {
+		t.Errorf("unexpected claims: %s", claims)
+	}
+}
+
+func Test_installationAuth_currentToken(t *testing.T) {
+	_, app := testApp(t)
+	a, err := newAppAuth(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	expires := time.Now().Add(time.Hour)
+	auth := &installationAuth{
+		app: a,
+		id:  7,
+		api: "https://api.github.com",
+		doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			requests++
+			if req.Method != "POST" || req.URL.Path != "/app/installations/7/access_tokens" {
+				t.Errorf("unexpected request %s %s", req.Method, req.URL)
+			}
+			if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+				t.Errorf("expected JWT authentication; got %s", req.Header.Get("Authorization"))
+			}
+			body := fmt.Sprintf(`{"token":"token-%d","expires_at":%q}`, requests, expires.Format(time.RFC3339))
+			return jsonResponse(req, http.StatusCreated, body), nil
+		}),
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := auth.currentToken(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("expected cached token-1; got %s", token)
+		}
+	}
+
+	// Expires soon
+	auth.expires = time.Now().Add(time.Minute)
+	token, err := auth.currentToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-2" {
+		t.Errorf("expected refreshed token-2; got %s", token)
+	}
+}
+
+func Test_repoPage_UnmarshalJSON(t *testing.T) {
+	for _, body := range []string{
+		`[{"full_name": "qvl/ghbackup"}]`,
+		`{"total_count": 1, "repositories": [{"full_name": "qvl/ghbackup"}]}`,
+	} {
+		var p repoPage
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatal(err)
+		}
+		if len(p) != 1 || p[0].Path != "qvl/ghbackup" {
+			t.Errorf("unexpected repos for %s: %v", body, p)
+		}
+	}
+}
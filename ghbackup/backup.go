@@ -1,43 +1,102 @@
 package ghbackup
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type repoState int
+// State describes the outcome of the backup of a single repository.
+type State int
 
+// Possible states of a repository after a backup.
 const (
-	stateNew = iota
-	stateChanged
-	stateUnchanged
-	stateFailed
+	StateNew State = iota
+	StateChanged
+	StateUnchanged
+	StateFailed
+	// StateSkipped means the repository has been excluded by a Filter
+	// or it is a wiki that has not been created.
+	StateSkipped
+	// StateOrphaned means a mirror exists in Config.Dir
+	// but the repository is no longer listed by GitHub.
+	StateOrphaned
 )
 
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateChanged:
+		return "updated"
+	case StateUnchanged:
+		return "unchanged"
+	case StateFailed:
+		return "failed"
+	case StateSkipped:
+		return "skipped"
+	case StateOrphaned:
+		return "orphaned"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Context that keeps the values of its parent but is never canceled.
+// git commands are run with it to let them finish when a backup is stopped;
+// killing git while it holds ref locks leaves lock files that make the next run fail.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
 // Clone new repo or pull in existing repo.
 // Returns state of repo.
-func (c Config) backup(r repo) (repoState, error) {
-	repoDir := getRepoDir(c.Dir, r.Path, c.Account)
+// Running git commands are not aborted when the context is canceled.
+func (c Config) backup(ctx context.Context, r repo) (State, error) {
+	ctx = detachedContext{ctx}
+	repoDir := c.repoDir(r)
 
 	repoExists, err := exists(repoDir)
 	if err != nil {
-		return stateFailed, fmt.Errorf("cannot check if repo exists: %v", err)
+		return StateFailed, fmt.Errorf("cannot check if repo exists: %v", err)
 	}
 
 	var cmd *exec.Cmd
 	if repoExists {
+		if err := c.setRemoteURL(ctx, r, repoDir); err != nil {
+			return StateFailed, err
+		}
 		c.Log.Printf("Updating %s", r.Path)
-		cmd = exec.Command("git", "remote", "update")
+		cmd = exec.CommandContext(ctx, "git", "remote", "update")
 		cmd.Dir = repoDir
 	} else {
 		c.Log.Printf("Cloning %s", r.Path)
-		cmd = exec.Command("git", "clone", "--mirror", "--no-checkout", "--progress", getCloneURL(r, c.Secret), repoDir)
+		cmd = exec.CommandContext(ctx, "git", "clone", "--mirror", "--no-checkout", "--progress", c.cloneURL(r), repoDir)
 	}
+	env, secret, err := c.gitEnv(ctx, r)
+	if err != nil {
+		return StateFailed, err
+	}
+	cmd.Env = env
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		if !repoExists {
@@ -45,11 +104,145 @@ func (c Config) backup(r repo) (repoState, error) {
 			// if it was a clean clone only
 			_ = os.RemoveAll(repoDir)
 		}
-		return stateFailed, fmt.Errorf("error running command %v (%v): %v (%v)", maskSecrets(cmd.Args, []string{c.Secret}), cmd.Path, string(out), err)
+		// Wikis can be enabled without ever being created
+		if r.wiki && !repoExists && repoNotFound(string(out)) {
+			c.Log.Printf("Skipping %s (not created)", r.Path)
+			return StateSkipped, nil
+		}
+		return StateFailed, fmt.Errorf("error running command %v (%v): %v (%v)", maskSecrets(cmd.Args, []string{secret}), cmd.Path, maskSecrets([]string{string(out)}, []string{secret})[0], err)
+	}
+	// Used to find the mirror after the repo has been renamed
+	if err := c.setRepoID(ctx, r); err != nil {
+		return StateFailed, err
 	}
 	return gitState(repoExists, string(out)), nil
 }
 
+// Reports if git failed because a repository does not exist.
+// Failed authentication is not included; a wiki that cannot be accessed must not be reported as not created.
+func repoNotFound(out string) bool {
+	out = strings.ToLower(out)
+	for _, denied := range []string{"authentication failed", "could not read username", "permission denied"} {
+		if strings.Contains(out, denied) {
+			return false
+		}
+	}
+	// HTTPS responds with 404 and GitHub over SSH with an error message
+	return strings.Contains(out, "fatal: repository '") && strings.Contains(out, "' not found") ||
+		strings.Contains(out, "error: repository not found")
+}
+
+// Make sure the remote of an existing mirror points to the URL of the repo.
+// Older versions of ghbackup saved the secret in the remote URL;
+// it is removed from the git config of the mirror this way.
+func (c Config) setRemoteURL(ctx context.Context, r repo, repoDir string) error {
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", "remote.origin.url")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("cannot get remote URL of %s: %v", repoDir, err)
+	}
+	current := strings.TrimSpace(string(out))
+	if current == c.cloneURL(r) {
+		return nil
+	}
+	if u, err := url.Parse(current); err == nil && u.User != nil {
+		c.Log.Printf("Removing credentials from git config of %s", r.Path)
+	}
+	cmd = exec.CommandContext(ctx, "git", "remote", "set-url", "origin", c.cloneURL(r))
+	cmd.Dir = repoDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot set remote URL of %s: %s (%v)", repoDir, out, err)
+	}
+	return nil
+}
+
+// Get the URL to clone a repo from depending on the protocol.
+func (c Config) cloneURL(r repo) string {
+	if c.Protocol == "ssh" {
+		return r.SSHURL
+	}
+	return r.URL
+}
+
+// Get the environment for git commands and the secret it contains.
+// For HTTPS, the secret is passed as HTTP header for the host of the repo only.
+// This way it is never saved in the git config of the mirror.
+// For SSH, the configured key and known_hosts file are passed to ssh.
+func (c Config) gitEnv(ctx context.Context, r repo) ([]string, string, error) {
+	// Fail instead of waiting for input when credentials are missing
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	// Pairs of keys and values passed to git as config
+	var config []string
+	if key := c.caInfoKey(r); key != "" {
+		config = append(config, key, c.CAFile)
+	}
+	var secret string
+	if c.Protocol == "ssh" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand(c.SSHKey, c.SSHKnownHosts))
+	} else if r.auth != nil {
+		s, err := r.auth.gitSecret(ctx)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot get credentials for %s: %v", r.Path, err)
+		}
+		if u, err := url.Parse(r.URL); err == nil && s != "" {
+			secret = s
+			auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + secret))
+			config = append(config, "http."+u.Scheme+"://"+u.Host+"/.extraHeader", "Authorization: Basic "+auth)
+		}
+	}
+	return append(env, gitConfigEnv(config)...), secret, nil
+}
+
+// Get the git config key for the CA file of the server of a repo.
+// The key is scoped to the host of the API, since it replaces the system certificates
+// and repos of the public github.com and gitlab.com must still be verified with them.
+func (c Config) caInfoKey(r repo) string {
+	api := c.repoAPI(r)
+	if c.CAFile == "" || api == r.host().defaultAPI() {
+		return ""
+	}
+	u, err := url.Parse(api)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return "http." + u.Scheme + "://" + u.Host + "/.sslCAInfo"
+}
+
+// Get the environment variables to pass pairs of keys and values as config to git.
+// Requires git 2.31 or newer.
+func gitConfigEnv(config []string) []string {
+	if len(config) == 0 {
+		return nil
+	}
+	env := []string{"GIT_CONFIG_COUNT=" + strconv.Itoa(len(config)/2)}
+	for i := 0; i < len(config); i += 2 {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, config[i]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, config[i+1]),
+		)
+	}
+	return env
+}
+
+// Get the ssh command used by git.
+// BatchMode makes ssh fail instead of asking for passwords or unknown host keys.
+func sshCommand(key, knownHosts string) string {
+	cmd := "ssh -o BatchMode=yes"
+	if key != "" {
+		cmd += " -i " + shellQuote(key) + " -o IdentitiesOnly=yes"
+	}
+	if knownHosts != "" {
+		cmd += " -o UserKnownHostsFile=" + shellQuote(knownHosts) + " -o StrictHostKeyChecking=yes"
+	}
+	return cmd
+}
+
+// Quote a string to be used as a single argument in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
 // maskSecrets hides sensitive data
 func maskSecrets(values, secrets []string) []string {
 	out := make([]string, len(values))
@@ -57,6 +250,9 @@ func maskSecrets(values, secrets []string) []string {
 		out[vIndex] = value
 	}
 	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
 		for vIndex, value := range out {
 			out[vIndex] = strings.Replace(value, secret, "###", -1)
 		}
@@ -64,10 +260,18 @@ func maskSecrets(values, secrets []string) []string {
 	return out
 }
 
-func getRepoDir(backupDir, repoPath, account string) string {
+// Get the directory of the mirror of a repo.
+func (c Config) repoDir(r repo) string {
+	if r.dir != "" {
+		return r.dir
+	}
+	return getRepoDir(filepath.Join(c.Dir, c.repoHost(r)), r.Path, len(c.Accounts) == 1)
+}
+
+func getRepoDir(backupDir, repoPath string, singleAccount bool) string {
 	repoGit := repoPath + ".git"
 	// For single account, skip sub-directories
-	if account != "" {
+	if singleAccount {
 		return filepath.Join(backupDir, path.Base(repoGit))
 	}
 	return filepath.Join(backupDir, repoGit)
@@ -85,28 +289,29 @@ func exists(f string) (bool, error) {
 	return true, nil
 }
 
-// Add secret token to URL of private repos.
-// Allows cloning without manual authentication or SSH setup.
-// However, this saves the secret in the git config file.
-func getCloneURL(r repo, secret string) string {
-	if !r.Private {
-		return r.URL
-	}
-	u, err := url.Parse(r.URL)
-	if err != nil {
-		return ""
-	}
-	u.User = url.User(secret)
-	return u.String()
+// Get the total size of all files in a directory.
+// Missing directories have a size of 0.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
 }
 
 // Get the state of a repo from command output.
-func gitState(repoExisted bool, out string) repoState {
+func gitState(repoExisted bool, out string) State {
 	if !repoExisted {
-		return stateNew
+		return StateNew
 	}
 	if lines := strings.Split(out, "\n"); len(lines) > 2 {
-		return stateChanged
+		return StateChanged
 	}
-	return stateUnchanged
+	return StateUnchanged
 }
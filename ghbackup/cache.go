@@ -0,0 +1,143 @@
+package ghbackup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File in Config.Dir caching responses of the GitHub API used to list repositories.
+const cacheFile = "api_cache.json"
+
+// A cached response.
+type cacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Needed to get the next page
+	Link string          `json:"link,omitempty"`
+	Body json.RawMessage `json:"body"`
+}
+
+// Doer making conditional GET requests with the ETag or Last-Modified of cached responses.
+// When GitHub responds with 304 Not Modified, the cached response is returned instead.
+// Conditional requests answered with 304 do not count against the rate limit.
+type cacheDoer struct {
+	doer Doer
+	file string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// Keys of the requests of this run; only they are saved
+	used map[string]bool
+}
+
+// Load the cache from a file.
+// A missing file results in an empty cache.
+func newCacheDoer(doer Doer, file string) (*cacheDoer, error) {
+	d := &cacheDoer{doer: doer, file: file, entries: map[string]cacheEntry{}, used: map[string]bool{}}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &d.entries); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", file, err)
+	}
+	return d, nil
+}
+
+func (d *cacheDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return d.doer.Do(req)
+	}
+	key := cacheKey(req)
+	d.mu.Lock()
+	entry, cached := d.entries[key]
+	d.used[key] = true
+	d.mu.Unlock()
+	if cached {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		} else if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	res, err := d.doer.Do(req)
+	if err != nil {
+		return res, err
+	}
+	if cached && res.StatusCode == http.StatusNotModified {
+		_ = res.Body.Close()
+		res.StatusCode = http.StatusOK
+		res.Status = "200 OK"
+		res.Header.Del("Link")
+		if entry.Link != "" {
+			res.Header.Set("Link", entry.Link)
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(entry.Body))
+		res.ContentLength = int64(len(entry.Body))
+		return res, nil
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("ETag") == "" && res.Header.Get("Last-Modified") == "" {
+		return res, nil
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	// Bodies are saved as JSON; anything else is not cached
+	if !json.Valid(body) {
+		return res, nil
+	}
+	d.mu.Lock()
+	d.entries[key] = cacheEntry{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Link:         res.Header.Get("Link"),
+		Body:         body,
+	}
+	d.mu.Unlock()
+	return res, nil
+}
+
+// Get the key of the cached response of a request.
+// Responses depend on the credentials; the same URL is listed for example by each App installation.
+// A hash of the credentials is used to not save them in the cache file.
+func cacheKey(req *http.Request) string {
+	key := req.URL.String()
+	auth := req.Header.Get("Authorization") + req.Header.Get("PRIVATE-TOKEN")
+	if auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		key += " " + hex.EncodeToString(sum[:8])
+	}
+	return key
+}
+
+// Save the responses of all requests of this run.
+func (d *cacheDoer) save() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries := map[string]cacheEntry{}
+	for key, entry := range d.entries {
+		if d.used[key] {
+			entries[key] = entry
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(d.file), 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(d.file, entries)
+}
@@ -0,0 +1,133 @@
+package ghbackup
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_cacheDoer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	file := filepath.Join(dir, cacheFile)
+	next := `<https://api.github.com/user/repos?page=2>; rel="next"`
+
+	requests := 0
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			return jsonResponse(req, http.StatusNotModified, ""), nil
+		}
+		res := jsonResponse(req, http.StatusOK, `[{"full_name":"qvl/ghbackup"}]`)
+		res.Header.Set("ETag", `"v1"`)
+		res.Header.Set("Link", next)
+		return res, nil
+	})
+
+	// The second run is answered from the cache saved by the first one
+	for run := 0; run < 2; run++ {
+		d, err := newCacheDoer(doer, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", "https://api.github.com/user/repos", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := d.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var repos repoPage
+		if err := decodeResponse(res, &repos); err != nil {
+			t.Fatal(err)
+		}
+		if len(repos) != 1 || repos[0].Path != "qvl/ghbackup" {
+			t.Errorf("run %d: unexpected repos %v", run, repos)
+		}
+		if link := getNextURL(res.Header); link != "https://api.github.com/user/repos?page=2" {
+			t.Errorf("run %d: unexpected next URL %s", run, link)
+		}
+		if err := d.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests; got %d", requests)
+	}
+
+	// Bodies are saved as readable JSON
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"full_name"`) {
+		t.Errorf("expected body to be saved as JSON; got %s", b)
+	}
+}
+
+func Test_cacheDoer_credentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	file := filepath.Join(dir, cacheFile)
+
+	// Each credential gets a different response with its own ETag
+	etags := map[string]string{"token a": `"a"`, "token b": `"b"`}
+	notModified := 0
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		etag := etags[req.Header.Get("Authorization")]
+		if req.Header.Get("If-None-Match") == etag {
+			notModified++
+			return jsonResponse(req, http.StatusNotModified, ""), nil
+		}
+		res := jsonResponse(req, http.StatusOK, `[]`)
+		res.Header.Set("ETag", etag)
+		return res, nil
+	})
+
+	for run := 0; run < 2; run++ {
+		d, err := newCacheDoer(doer, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range []string{"token a", "token b"} {
+			req, err := http.NewRequest("GET", "https://api.github.com/installation/repositories", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", token)
+			res, err := d.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+		}
+		if err := d.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if notModified != 2 {
+		t.Errorf("expected both credentials to be answered from the cache; got %d", notModified)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "token") {
+		t.Errorf("expected credentials not to be saved in the cache")
+	}
+}
@@ -0,0 +1,313 @@
+package ghbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Export metadata of a repository from the GitHub API as JSON files.
+// Files are saved to a directory next to the mirror.
+func (c Config) export(ctx context.Context, r repo) error {
+	if r.wiki || r.gist || r.starred || !r.host().supportsExports() || !(c.Issues && r.HasIssues || c.Pulls || c.Releases) {
+		return nil
+	}
+	dir := c.metaDir(r)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	cursors, err := readCursors(dir)
+	if err != nil {
+		return err
+	}
+	// Save progress also if one of the exports fails
+	defer func() {
+		_ = writeJSONFile(filepath.Join(dir, cursorFile), cursors)
+	}()
+
+	if c.Issues && r.HasIssues {
+		if err := c.exportIssues(ctx, r, dir, cursors); err != nil {
+			return fmt.Errorf("cannot export issues of %s: %v", r.Path, err)
+		}
+	}
+	if c.Pulls {
+		if err := c.exportPulls(ctx, r, dir, cursors); err != nil {
+			return fmt.Errorf("cannot export pull requests of %s: %v", r.Path, err)
+		}
+	}
+	if c.Releases {
+		if err := c.exportReleases(ctx, r, dir); err != nil {
+			return fmt.Errorf("cannot export releases of %s: %v", r.Path, err)
+		}
+	}
+	return nil
+}
+
+// Get the directory for metadata of a repo.
+// It is next to the mirror as REPO.meta.
+func (c Config) metaDir(r repo) string {
+	return metaDirOf(c.repoDir(r))
+}
+
+// Export issues, issue comments, labels and milestones.
+// Issues and comments are synced incrementally
+// by only requesting the ones updated since the last export.
+func (c Config) exportIssues(ctx context.Context, r repo, dir string, cursors map[string]time.Time) error {
+	base := c.repoAPI(r) + "/repos/" + r.Path
+	if err := c.syncSince(ctx, r, dir, cursors, "issues", base+"/issues?state=all&sort=updated&direction=asc"); err != nil {
+		return err
+	}
+	if err := c.syncSince(ctx, r, dir, cursors, "issue_comments", base+"/issues/comments?sort=updated&direction=asc"); err != nil {
+		return err
+	}
+
+	// Labels and milestones are few; always get all of them
+	for name, u := range map[string]string{
+		"labels":     base + "/labels?per_page=100",
+		"milestones": base + "/milestones?state=all&per_page=100",
+	} {
+		items, err := getAll(ctx, u, r.auth, c.Doer)
+		if err != nil {
+			return err
+		}
+		if err := writeJSONFile(filepath.Join(dir, name+".json"), items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export pull requests with their review comments, reviews and timeline events.
+// Only pull requests updated since the last export are requested.
+// Reviews and timelines are saved per pull request in pull_reviews/NUMBER.json and pull_timelines/NUMBER.json.
+func (c Config) exportPulls(ctx context.Context, r repo, dir string, cursors map[string]time.Time) error {
+	base := c.repoAPI(r) + "/repos/" + r.Path
+	if err := c.syncSince(ctx, r, dir, cursors, "pull_comments", base+"/pulls/comments?sort=updated&direction=asc"); err != nil {
+		return err
+	}
+
+	// The pulls endpoint does not support "since";
+	// sort by update time and stop at the first pull request that has not been updated.
+	file := filepath.Join(dir, "pulls.json")
+	since, err := getCursor(cursors, "pulls", file)
+	if err != nil {
+		return err
+	}
+	var pulls []json.RawMessage
+	err = eachPage(ctx, base+"/pulls?state=all&sort=updated&direction=desc&per_page=100", r.auth, c.Doer, func(page []json.RawMessage) (bool, error) {
+		for _, raw := range page {
+			var it item
+			if err := json.Unmarshal(raw, &it); err != nil {
+				return false, fmt.Errorf("cannot decode pull request: %v", err)
+			}
+			if it.UpdatedAt.Before(since) {
+				return false, nil
+			}
+			pulls = append(pulls, raw)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"pull_reviews", "pull_timelines"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	for _, raw := range pulls {
+		var pull struct {
+			Number int `json:"number"`
+		}
+		if err := json.Unmarshal(raw, &pull); err != nil {
+			return fmt.Errorf("cannot decode pull request: %v", err)
+		}
+		for sub, u := range map[string]string{
+			"pull_reviews":   fmt.Sprintf("%s/pulls/%d/reviews?per_page=100", base, pull.Number),
+			"pull_timelines": fmt.Sprintf("%s/issues/%d/timeline?per_page=100", base, pull.Number),
+		} {
+			items, err := getAll(ctx, u, r.auth, c.Doer)
+			if err != nil {
+				return err
+			}
+			if err := writeJSONFile(filepath.Join(dir, sub, fmt.Sprintf("%d.json", pull.Number)), items); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Pull requests are saved last so an interrupted export is repeated next time
+	latest, err := mergeJSONFile(file, pulls)
+	if err != nil {
+		return err
+	}
+	if latest.After(cursors["pulls"]) {
+		cursors["pulls"] = latest
+	}
+	return nil
+}
+
+// Sync a list that supports the "since" parameter into a JSON file.
+// Only items updated since the last sync are requested.
+func (c Config) syncSince(ctx context.Context, r repo, dir string, cursors map[string]time.Time, name, listURL string) error {
+	file := filepath.Join(dir, name+".json")
+	u := listURL + "&per_page=100"
+	since, err := getCursor(cursors, name, file)
+	if err != nil {
+		return err
+	}
+	if !since.IsZero() {
+		u += "&since=" + url.QueryEscape(since.Format(time.RFC3339))
+	}
+	items, err := getAll(ctx, u, r.auth, c.Doer)
+	if err != nil {
+		return err
+	}
+	latest, err := mergeJSONFile(file, items)
+	if err != nil {
+		return err
+	}
+	if latest.After(cursors[name]) {
+		cursors[name] = latest
+	}
+	return nil
+}
+
+// Get the time of the latest exported item of a list.
+// Returns the zero time to get everything again if the file has been removed.
+func getCursor(cursors map[string]time.Time, name, file string) (time.Time, error) {
+	fileExists, err := exists(file)
+	if err != nil || !fileExists {
+		return time.Time{}, err
+	}
+	return cursors[name], nil
+}
+
+// Get all items of a list from the GitHub API.
+// Follow all "next" links.
+func getAll(ctx context.Context, listURL string, auth authenticator, doer Doer) ([]json.RawMessage, error) {
+	var all []json.RawMessage
+	err := eachPage(ctx, listURL, auth, doer, func(page []json.RawMessage) (bool, error) {
+		all = append(all, page...)
+		return true, nil
+	})
+	return all, err
+}
+
+// Call fn for each page of a list from the GitHub API.
+// Follow "next" links as long as fn returns true.
+func eachPage(ctx context.Context, currentURL string, auth authenticator, doer Doer, fn func([]json.RawMessage) (bool, error)) error {
+	for currentURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", currentURL, nil)
+		if err != nil {
+			return fmt.Errorf("cannot create request: %v", err)
+		}
+		if auth != nil {
+			if err := auth.authorize(req); err != nil {
+				return err
+			}
+		}
+		res, err := doer.Do(req)
+		if err != nil {
+			return fmt.Errorf("cannot get %s: %v", currentURL, err)
+		}
+		var page []json.RawMessage
+		if err := decodeResponse(res, &page); err != nil {
+			return err
+		}
+		next, err := fn(page)
+		if err != nil || !next {
+			return err
+		}
+		currentURL = getNextURL(res.Header)
+	}
+	return nil
+}
+
+// Fields used to merge exported items
+type item struct {
+	ID        int64     `json:"id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Merge items into a JSON file with a list of items.
+// Items are identified by their ID; existing items are replaced.
+// Returns the latest update time of all new items.
+func mergeJSONFile(file string, items []json.RawMessage) (time.Time, error) {
+	var latest time.Time
+	var existing []json.RawMessage
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return latest, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &existing); err != nil {
+			return latest, fmt.Errorf("cannot decode %s: %v", file, err)
+		}
+	}
+
+	index := map[int64]int{}
+	for i, raw := range existing {
+		var it item
+		if err := json.Unmarshal(raw, &it); err != nil {
+			return latest, fmt.Errorf("cannot decode %s: %v", file, err)
+		}
+		index[it.ID] = i
+	}
+	for _, raw := range items {
+		var it item
+		if err := json.Unmarshal(raw, &it); err != nil {
+			return latest, fmt.Errorf("cannot decode item: %v", err)
+		}
+		if it.UpdatedAt.After(latest) {
+			latest = it.UpdatedAt
+		}
+		if i, ok := index[it.ID]; ok {
+			existing[i] = raw
+			continue
+		}
+		index[it.ID] = len(existing)
+		existing = append(existing, raw)
+	}
+
+	return latest, writeJSONFile(file, existing)
+}
+
+// Write v as indented JSON.
+// The file is replaced atomically to not leave partial files behind.
+func writeJSONFile(file string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// File in the metadata directory storing the time of the latest exported items.
+const cursorFile = "cursors.json"
+
+func readCursors(dir string) (map[string]time.Time, error) {
+	cursors := map[string]time.Time{}
+	b, err := ioutil.ReadFile(filepath.Join(dir, cursorFile))
+	if os.IsNotExist(err) {
+		return cursors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &cursors); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", cursorFile, err)
+	}
+	return cursors, nil
+}
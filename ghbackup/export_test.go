@@ -0,0 +1,178 @@
+package ghbackup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_mergeJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	file := filepath.Join(dir, "issues.json")
+
+	first := []json.RawMessage{
+		json.RawMessage(`{"id":1,"title":"a","updated_at":"2020-01-01T00:00:00Z"}`),
+		json.RawMessage(`{"id":2,"title":"b","updated_at":"2020-01-02T00:00:00Z"}`),
+	}
+	latest, err := mergeJSONFile(file, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC); !latest.Equal(want) {
+		t.Errorf("latest = %v, want %v", latest, want)
+	}
+
+	second := []json.RawMessage{
+		json.RawMessage(`{"id":1,"title":"a2","updated_at":"2020-01-03T00:00:00Z"}`),
+		json.RawMessage(`{"id":3,"title":"c","updated_at":"2020-01-04T00:00:00Z"}`),
+	}
+	if _, err := mergeJSONFile(file, second); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		ID    int64
+		Title string
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		ID    int64
+		Title string
+	}{{1, "a2"}, {2, "b"}, {3, "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %v, want %v", got, want)
+	}
+}
+
+func Test_Config_export_issues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	var since []string
+	c := Config{
+		Dir:      dir,
+		API:      "https://api.github.com",
+		Accounts: []Account{{Name: "qvl"}},
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/repos/qvl/ghbackup/issues":
+				since = append(since, req.URL.Query().Get("since"))
+				return jsonResponse(req, 200, `[{"id":1,"updated_at":"2020-01-02T00:00:00Z"}]`), nil
+			case "/repos/qvl/ghbackup/issues/comments":
+				return jsonResponse(req, 200, `[{"id":5,"updated_at":"2020-01-01T00:00:00Z"}]`), nil
+			case "/repos/qvl/ghbackup/labels", "/repos/qvl/ghbackup/milestones":
+				return jsonResponse(req, 200, `[{"id":9}]`), nil
+			}
+			t.Errorf("unexpected request to %s", req.URL)
+			return jsonResponse(req, 404, ""), nil
+		}),
+	}
+	r := repo{Path: "qvl/ghbackup", HasIssues: true}
+
+	c.Issues = true
+	for i := 0; i < 2; i++ {
+		if err := c.export(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"", "2020-01-02T00:00:00Z"}; !reflect.DeepEqual(since, want) {
+		t.Errorf("since = %v, want %v", since, want)
+	}
+	for _, f := range []string{"issues.json", "issue_comments.json", "labels.json", "milestones.json", cursorFile} {
+		if _, err := os.Stat(filepath.Join(dir, "ghbackup.meta", f)); err != nil {
+			t.Errorf("expected %s to be exported: %v", f, err)
+		}
+	}
+}
+
+func Test_Config_export_pulls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	pulls := `[
+		{"id":12,"number":2,"updated_at":"2020-01-03T00:00:00Z"},
+		{"id":11,"number":1,"updated_at":"2020-01-01T00:00:00Z"}
+	]`
+	var requested []string
+	c := Config{
+		Dir:      dir,
+		API:      "https://api.github.com",
+		Accounts: []Account{{Name: "qvl"}},
+		Pulls:    true,
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.URL.Path)
+			switch req.URL.Path {
+			case "/repos/qvl/ghbackup/pulls":
+				return jsonResponse(req, 200, pulls), nil
+			case "/repos/qvl/ghbackup/pulls/comments",
+				"/repos/qvl/ghbackup/pulls/1/reviews", "/repos/qvl/ghbackup/issues/1/timeline",
+				"/repos/qvl/ghbackup/pulls/2/reviews", "/repos/qvl/ghbackup/issues/2/timeline":
+				return jsonResponse(req, 200, `[]`), nil
+			}
+			t.Errorf("unexpected request to %s", req.URL)
+			return jsonResponse(req, 404, ""), nil
+		}),
+	}
+	r := repo{Path: "qvl/ghbackup"}
+
+	if err := c.export(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 6 {
+		t.Errorf("expected reviews and timelines of all pull requests; got %v", requested)
+	}
+
+	// Only pull request 2 has been updated since the last export
+	pulls = `[
+		{"id":12,"number":2,"updated_at":"2020-01-05T00:00:00Z"},
+		{"id":11,"number":1,"updated_at":"2020-01-01T00:00:00Z"}
+	]`
+	requested = nil
+	if err := c.export(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/repos/qvl/ghbackup/issues/2/timeline",
+		"/repos/qvl/ghbackup/pulls",
+		"/repos/qvl/ghbackup/pulls/2/reviews",
+		"/repos/qvl/ghbackup/pulls/comments",
+	}
+	sort.Strings(requested)
+	if !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested = %v, want %v", requested, want)
+	}
+	for _, f := range []string{"pulls.json", "pull_comments.json", "pull_reviews/1.json", "pull_timelines/2.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "ghbackup.meta", f)); err != nil {
+			t.Errorf("expected %s to be exported: %v", f, err)
+		}
+	}
+}
@@ -1,51 +1,203 @@
 package ghbackup
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"strings"
 )
 
-// Get repositories from Github.
-// Follow all "next" links.
-func fetch(account, secret, api string, doer Doer) ([]repo, error) {
+// Lists repositories of GitHub accounts.
+type repoLister interface {
+	// Get the repositories of an account.
+	// Without account, all repositories accessible with auth are returned.
+	list(ctx context.Context, account string, auth authenticator) ([]repo, error)
+}
+
+// Lists repositories with the GitHub REST API.
+type restLister struct {
+	api  string
+	doer Doer
+}
+
+func (l restLister) list(ctx context.Context, account string, auth authenticator) ([]repo, error) {
+	return fetch(ctx, account, auth, l.api, l.doer)
+}
+
+// Get the lister for the configured API.
+func (c Config) lister() repoLister {
+	if c.GraphQL {
+		return graphQLLister{api: c.API, doer: c.Doer}
+	}
+	return restLister{api: c.API, doer: c.Doer}
+}
+
+// Get repositories of all accounts from their providers.
+// Without accounts, all repositories the authenticated user has access to are returned.
+func (c Config) fetchAccounts(ctx context.Context) ([]repo, error) {
+	if c.App != nil {
+		return c.fetchApp(ctx)
+	}
+	accounts := c.Accounts
+	if len(accounts) == 0 {
+		accounts = []Account{{}}
+	}
+	var allRepos []repo
+	for _, account := range accounts {
+		p := c.provider(account)
+		repos, err := p.repos(ctx, c, account)
+		if err != nil {
+			if account.Name == "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("cannot get repos of %s: %v", account.Name, err)
+		}
+		for i := range repos {
+			repos[i].provider = p
+		}
+		allRepos = append(allRepos, repos...)
+	}
+	return allRepos, nil
+}
+
+// Get repositories of the installations of a GitHub App.
+// Without an installation ID, all installations on the accounts are used.
+// Without accounts, all installations are used.
+func (c Config) fetchApp(ctx context.Context) ([]repo, error) {
+	app, err := newAppAuth(*c.App)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{c.App.InstallationID}
+	if c.App.InstallationID == 0 {
+		installations, err := getInstallations(ctx, app, c.API, c.Doer)
+		if err != nil {
+			return nil, err
+		}
+		ids = nil
+		for _, i := range installations {
+			if len(c.Accounts) == 0 || hasAccount(c.Accounts, i.Account.Login) {
+				ids = append(ids, i.ID)
+			}
+		}
+	}
+
 	var allRepos []repo
+	for _, id := range ids {
+		auth := &installationAuth{app: app, id: id, api: c.API, doer: c.Doer}
+		repos, err := c.lister().list(ctx, "", auth)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get repos of installation %d: %v", id, err)
+		}
+		for _, r := range repos {
+			if len(c.Accounts) == 0 || hasAccount(c.Accounts, path.Dir(r.Path)) {
+				allRepos = append(allRepos, r)
+			}
+		}
+	}
+	return allRepos, nil
+}
 
-	currentURL, err := getURL(account, secret, api, doer)
+// Get the API URL of an account.
+// Config.API belongs to the provider of Config; accounts of other providers default to their public API.
+func (c Config) accountAPI(a Account) string {
+	if a.API != "" {
+		return a.API
+	}
+	if p := c.provider(a); p != c.provider(Account{}) {
+		return p.defaultAPI()
+	}
+	return c.API
+}
+
+// Get the API URL of the account a repo belongs to.
+func (c Config) repoAPI(r repo) string {
+	if r.api != "" {
+		return r.api
+	}
+	return c.API
+}
+
+// Get the host of a repo whose account is not on Config.API.
+// Mirrors of these repos are saved in a sub-directory named after the host
+// to not collide with repositories of the same name on other hosts.
+// Returns an empty string for repos on Config.API.
+func (c Config) repoHost(r repo) string {
+	api := c.repoAPI(r)
+	if api == c.API {
+		return ""
+	}
+	u, err := url.Parse(api)
 	if err != nil {
-		return allRepos, err
+		return ""
 	}
+	return u.Host
+}
+
+// Get the name of a repo used in results and the saved state.
+// The full name is prefixed with the host for repos not on Config.API.
+func (c Config) repoName(r repo) string {
+	if host := c.repoHost(r); host != "" {
+		return host + "/" + r.Path
+	}
+	return r.Path
+}
+
+func hasAccount(accounts []Account, name string) bool {
+	for _, a := range accounts {
+		if strings.EqualFold(a.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get repositories from Github.
+// Follow all "next" links.
+func fetch(ctx context.Context, account string, auth authenticator, api string, doer Doer) ([]repo, error) {
+	currentURL, err := getURL(ctx, account, auth, api, doer)
+	if err != nil {
+		return nil, err
+	}
+	repos, err := fetchURL(ctx, currentURL, auth, doer)
+	if err != nil {
+		return nil, err
+	}
+	return selectRepos(repos, account), nil
+}
+
+// Get all repositories of a list starting at currentURL.
+// Follow all "next" links.
+func fetchURL(ctx context.Context, currentURL string, auth authenticator, doer Doer) ([]repo, error) {
+	var allRepos []repo
 
 	// Go through all pages
 	for {
-		req, err := http.NewRequest("GET", currentURL, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", currentURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("cannot create request: %v", err)
 		}
-		if secret != "" {
-			// For token authentication `account` will be ignored
-			req.SetBasicAuth(account, secret)
+		if err := auth.authorize(req); err != nil {
+			return nil, err
 		}
 		res, err := doer.Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("cannot get repos: %v", err)
 		}
-		defer func() {
-			_ = res.Body.Close()
-		}()
-		if res.StatusCode >= 300 {
-			return nil, fmt.Errorf("bad response from %s: %v", res.Request.URL, res.Status)
-		}
 
-		var repos []repo
-		err = json.NewDecoder(res.Body).Decode(&repos)
-		if err != nil {
-			return nil, fmt.Errorf("cannot decode JSON response: %v", err)
+		var repos repoPage
+		if err := decodeResponse(res, &repos); err != nil {
+			return nil, err
 		}
 
-		allRepos = append(allRepos, selectRepos(repos, account)...)
+		for _, r := range repos {
+			r.auth = auth
+			allRepos = append(allRepos, r)
+		}
 
 		// Set url for next iteration
 		currentURL = getNextURL(res.Header)
@@ -57,22 +209,60 @@ func fetch(account, secret, api string, doer Doer) ([]repo, error) {
 	}
 }
 
-func getURL(account, secret, api string, doer Doer) (string, error) {
-	user := "user"
-	if secret == "" {
-		category, err := getCategory(account, api, doer)
-		if err != nil {
-			return "", err
+// A page of repositories.
+// Most endpoints return a list but the one for app installations wraps it in an object.
+type repoPage []repo
+
+func (p *repoPage) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '{' {
+		var wrapped struct {
+			Repositories []repo `json:"repositories"`
 		}
-		user = category + "/" + account
+		err := json.Unmarshal(b, &wrapped)
+		*p = wrapped.Repositories
+		return err
 	}
-	url := api + "/" + user + "/repos?per_page=100"
+	var repos []repo
+	err := json.Unmarshal(b, &repos)
+	*p = repos
+	return err
+}
+
+// Check the status of a response and decode its JSON body into v.
+// Closes the body.
+func decodeResponse(res *http.Response, v interface{}) error {
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("bad response from %s: %v", res.Request.URL, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("cannot decode JSON response: %v", err)
+	}
+	return nil
+}
+
+func getURL(ctx context.Context, account string, auth authenticator, api string, doer Doer) (string, error) {
+	switch a := auth.(type) {
+	case *installationAuth:
+		return api + "/installation/repositories?per_page=100", nil
+	case secretAuth:
+		if a.secret != "" {
+			return api + "/user/repos?per_page=100", nil
+		}
+	}
+	category, err := getCategory(ctx, account, api, doer)
+	if err != nil {
+		return "", err
+	}
+	url := api + "/" + category + "/" + account + "/repos?per_page=100"
 	return url, nil
 }
 
 // Returns "users" or "orgs" depending on type of account
-func getCategory(account, api string, doer Doer) (string, error) {
-	req, err := http.NewRequest("GET", strings.Join([]string{api, "users", account}, "/"), nil)
+func getCategory(ctx context.Context, account, api string, doer Doer) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.Join([]string{api, "users", account}, "/"), nil)
 	if err != nil {
 		return "", fmt.Errorf("cannot create HTTP request: %v", err)
 	}
@@ -80,19 +270,12 @@ func getCategory(account, api string, doer Doer) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("cannot get user info: %v", err)
 	}
-	defer func() {
-		_ = res.Body.Close()
-	}()
-	if res.StatusCode >= 300 {
-		return "", fmt.Errorf("bad response from %s: %v", res.Request.URL, res.Status)
-	}
 
 	var a struct {
 		Type string
 	}
-	err = json.NewDecoder(res.Body).Decode(&a)
-	if err != nil {
-		return "", fmt.Errorf("cannot decode JSON response: %v", err)
+	if err := decodeResponse(res, &a); err != nil {
+		return "", err
 	}
 
 	if a.Type == "User" {
@@ -110,7 +293,7 @@ func selectRepos(repos []repo, account string) []repo {
 	}
 	var res []repo
 	for _, r := range repos {
-		if path.Dir(r.Path) == account {
+		if strings.EqualFold(path.Dir(r.Path), account) {
 			res = append(res, r)
 		}
 	}
@@ -0,0 +1,127 @@
+package ghbackup
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Compiled version of a Filter.
+type repoFilter struct {
+	Filter
+	include []matcher
+	exclude []matcher
+}
+
+// Reports if a full repository name matches a pattern.
+type matcher func(fullName string) bool
+
+// Compile all patterns of the filter.
+// Returns an error for invalid patterns.
+func (f Filter) compile() (repoFilter, error) {
+	include, err := compilePatterns(f.Include)
+	if err != nil {
+		return repoFilter{}, fmt.Errorf("invalid include pattern: %v", err)
+	}
+	exclude, err := compilePatterns(f.Exclude)
+	if err != nil {
+		return repoFilter{}, fmt.Errorf("invalid exclude pattern: %v", err)
+	}
+	return repoFilter{Filter: f, include: include, exclude: exclude}, nil
+}
+
+func compilePatterns(patterns []string) ([]matcher, error) {
+	var matchers []matcher
+	for _, p := range patterns {
+		m, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// Patterns enclosed in slashes are regular expressions, all others are globs.
+// Both are matched against the full name and the name of a repository.
+func compilePattern(pattern string) (matcher, error) {
+	var match func(name string) bool
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pattern, err)
+		}
+		match = re.MatchString
+	} else {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %v", pattern, err)
+		}
+		match = func(name string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}
+	}
+	return func(fullName string) bool {
+		return match(fullName) || match(path.Base(fullName))
+	}, nil
+}
+
+func matchAny(matchers []matcher, fullName string) bool {
+	for _, m := range matchers {
+		if m(fullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the reason why a repository should not be backed up.
+// Returns an empty string if the repository should be backed up.
+func (f repoFilter) skipReason(r repo) string {
+	switch {
+	case len(f.include) > 0 && !matchAny(f.include, r.Path):
+		return "not included"
+	case matchAny(f.exclude, r.Path):
+		return "excluded"
+	case f.SkipForks && r.Fork:
+		return "fork"
+	case f.SkipArchived && r.Archived:
+		return "archived"
+	case f.SkipDisabled && r.Disabled:
+		return "disabled"
+	case f.OnlyPrivate && !r.Private:
+		return "public"
+	case len(f.Topics) > 0 && !containsAny(f.Topics, r.Topics):
+		return "topic"
+	case len(f.Languages) > 0 && !containsAny(f.Languages, []string{r.Language}):
+		return "language"
+	case f.MaxSize > 0 && r.Size > f.MaxSize:
+		return fmt.Sprintf("size of %d KB", r.Size)
+	}
+	return ""
+}
+
+// Reports if any of the values is in list, ignoring case.
+func containsAny(list, values []string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if strings.EqualFold(l, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Split repos into the ones to back up and the skipped ones.
+func (f repoFilter) apply(repos []repo) (selected, skipped []repo) {
+	for _, r := range repos {
+		if f.skipReason(r) == "" {
+			selected = append(selected, r)
+		} else {
+			skipped = append(skipped, r)
+		}
+	}
+	return selected, skipped
+}
@@ -0,0 +1,117 @@
+package ghbackup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_repoFilter_apply(t *testing.T) {
+	repos := []repo{
+		{Path: "org/platform-api"},
+		{Path: "org/platform-sandbox"},
+		{Path: "org/website"},
+		{Path: "other/platform-tools"},
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{
+			name:   "empty",
+			filter: Filter{},
+			want:   []string{"org/platform-api", "org/platform-sandbox", "org/website", "other/platform-tools"},
+		},
+		{
+			name:   "include glob on name",
+			filter: Filter{Include: []string{"platform-*"}},
+			want:   []string{"org/platform-api", "org/platform-sandbox", "other/platform-tools"},
+		},
+		{
+			name:   "include glob on full name",
+			filter: Filter{Include: []string{"org/*"}},
+			want:   []string{"org/platform-api", "org/platform-sandbox", "org/website"},
+		},
+		{
+			name:   "exclude glob",
+			filter: Filter{Exclude: []string{"*-sandbox"}},
+			want:   []string{"org/platform-api", "org/website", "other/platform-tools"},
+		},
+		{
+			name:   "include and exclude",
+			filter: Filter{Include: []string{"platform-*"}, Exclude: []string{"*-sandbox"}},
+			want:   []string{"org/platform-api", "other/platform-tools"},
+		},
+		{
+			name:   "regular expression",
+			filter: Filter{Include: []string{"/^org/(website|platform-api)$/"}},
+			want:   []string{"org/platform-api", "org/website"},
+		},
+		{
+			name:   "regular expression on name",
+			filter: Filter{Include: []string{"/^platform-/"}},
+			want:   []string{"org/platform-api", "org/platform-sandbox", "other/platform-tools"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.filter.compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected, skipped := f.apply(repos)
+			var got []string
+			for _, r := range selected {
+				got = append(got, r.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %v, want %v", got, tt.want)
+			}
+			if len(selected)+len(skipped) != len(repos) {
+				t.Errorf("apply() lost repositories: %d selected, %d skipped", len(selected), len(skipped))
+			}
+		})
+	}
+}
+
+func Test_repoFilter_skipReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		repo   repo
+		want   string
+	}{
+		{"no filter", Filter{}, repo{Fork: true, Archived: true, Size: 1 << 20}, ""},
+		{"fork", Filter{SkipForks: true}, repo{Fork: true}, "fork"},
+		{"archived", Filter{SkipArchived: true}, repo{Archived: true}, "archived"},
+		{"public", Filter{OnlyPrivate: true}, repo{Private: false}, "public"},
+		{"private", Filter{OnlyPrivate: true}, repo{Private: true}, ""},
+		{"topic", Filter{Topics: []string{"backup"}}, repo{Topics: []string{"go"}}, "topic"},
+		{"matching topic", Filter{Topics: []string{"backup"}}, repo{Topics: []string{"go", "backup"}}, ""},
+		{"language", Filter{Languages: []string{"go"}}, repo{Language: "Go"}, ""},
+		{"too large", Filter{MaxSize: 100}, repo{Size: 101}, "size of 101 KB"},
+		{"small enough", Filter{MaxSize: 100}, repo{Size: 100}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.filter.compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.skipReason(tt.repo); got != tt.want {
+				t.Errorf("skipReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Filter_compile(t *testing.T) {
+	for _, f := range []Filter{
+		{Include: []string{"[platform"}},
+		{Exclude: []string{"/(/"}},
+	} {
+		if _, err := f.compile(); err == nil {
+			t.Errorf("expected error for %v", f)
+		}
+	}
+}
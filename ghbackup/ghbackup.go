@@ -4,8 +4,10 @@
 package ghbackup
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 )
 
 // Config should be passed to Run.
@@ -14,25 +16,224 @@ type Config struct {
 	Account string
 	Dir     string
 	// Optional:
-	Err     *log.Logger
-	Log     *log.Logger
-	Secret  string
+	// Additional accounts to back up in the same run.
+	// With a single account, repositories are saved directly in Dir.
+	// With multiple accounts, they are saved in a sub-directory per owner.
+	Accounts []Account
+	Err      *log.Logger
+	Log      *log.Logger
+	Secret   string
+	// Provider of the accounts; defaults to GitHub
+	Provider Provider
+	// URL of the API of Provider; defaults to https://api.github.com or https://gitlab.com/api/v4.
+	// For GitHub Enterprise Server use https://HOST/api/v3.
 	API     string
 	Workers int
+	// File with additional CA certificates in PEM format,
+	// for example for GitHub Enterprise Server with a self-signed certificate.
+	// Used for the API unless a custom Doer is set
+	// and by git for repos on the host of a non-public API.
+	CAFile string
+	// List repositories with the GraphQL API instead of the REST API.
+	// Requires a secret. Installations of a GitHub App are always listed with the REST API.
+	// Not used for other providers.
+	GraphQL bool
+	// Protocol used by git: "https" (default) or "ssh"
+	Protocol string
+	// Private key file and known_hosts file used with the "ssh" protocol.
+	// If empty, the defaults of ssh are used.
+	SSHKey        string
+	SSHKnownHosts string
+	// Authenticate as GitHub App instead of using Secret
+	App *App
+	// Also back up the wiki of each repository.
+	// Wikis are saved next to their repository as REPO.wiki.git.
+	Wikis bool
+	// Export issues, issue comments, labels and milestones as JSON.
+	// Files are saved next to the repository in REPO.meta.
+	Issues bool
+	// Export pull requests with review comments, reviews and timeline events as JSON.
+	// Files are saved next to the repository in REPO.meta.
+	Pulls bool
+	// Export releases as JSON and download their assets.
+	// Assets are saved to REPO.meta/releases/TAG.
+	Releases bool
+	// Also fetch the Git LFS objects of all refs with "git lfs fetch --all".
+	// Requires git-lfs to be installed.
+	LFS bool
+	// Also back up the gists of the accounts.
+	// Gists are saved in Dir as gists/ID.git with an index in gists/index.json.
+	// Not supported with App.
+	Gists bool
+	// Also back up the repositories starred by the accounts.
+	// They are saved in Dir as starred/OWNER/REPO.git
+	// and selected by StarredFilter instead of Filter.
+	// Wikis, metadata and LFS objects are not backed up for them.
+	// Not supported with App.
+	Starred       bool
+	StarredFilter Filter
+	// What to do with mirrors of repositories no longer listed by GitHub:
+	// OrphansKeep (default), OrphansArchive or OrphansDelete.
+	// Orphaned mirrors are always reported.
+	Orphans string
+	// Days after which orphaned mirrors are deleted with OrphansDelete; must be at least 1 then
+	OrphanDays int
+	// Run git for all repositories.
+	// By default, repositories that have not been pushed to since their last successful backup are not updated.
+	Force bool
+	// Waits between retries of a failed backup.
+	// Defaults to 5s, 15s, 45s, 90s and 180s. Use an empty slice to disable retries.
+	Retries []time.Duration
 	Doer
+	Filter
+	// State of all repositories; set during a run
+	state *runState
+}
+
+// App is a GitHub App used for authentication.
+// Installation tokens are requested and refreshed automatically.
+type App struct {
+	ID int64
+	// PEM encoded private key of the app
+	PrivateKey []byte
+	// Installation to back up.
+	// If 0, all installations of the app are used,
+	// limited to Config.Accounts if set.
+	InstallationID int64
+}
+
+// Account is a GitHub user or organization or a GitLab user or group to back up.
+// Projects in subgroups of a GitLab group are included.
+type Account struct {
+	Name string
+	// Overrides Config.Secret for this account
+	Secret string
+	// Overrides Config.Provider for this account
+	Provider Provider
+	// Overrides Config.API for this account,
+	// for example to back up accounts on github.com and GitHub Enterprise Server in one run.
+	// Accounts of a provider other than Config.Provider default to its public API.
+	// Repositories of accounts not on Config.API are saved in a sub-directory named after the host.
+	API string
+}
+
+// Filter selects the repositories to back up.
+// Patterns are matched against the full name ("owner/repo") and against the name of a repository.
+// A pattern enclosed in slashes like "/^platform-/" is a regular expression,
+// every other pattern is a glob as supported by path.Match.
+type Filter struct {
+	// If set, only repositories matching at least one of the patterns are backed up
+	Include []string
+	// Repositories matching any of the patterns are skipped
+	Exclude []string
+	// Skip repositories by their metadata
+	SkipForks    bool
+	SkipArchived bool
+	SkipDisabled bool
+	OnlyPrivate  bool
+	// If set, only repositories with at least one of the topics are backed up
+	Topics []string
+	// If set, only repositories with one of the primary languages are backed up
+	Languages []string
+	// Skip repositories larger than MaxSize kilobytes; 0 means no limit
+	MaxSize int
 }
 
 // Doer makes HTTP requests.
 // http.HTTPClient implements Doer but simpler implementations can be used too.
+// Run wraps the Doer to wait for rate limits and to retry server errors.
 type Doer interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// Result is returned by Run.
+// It lists the outcome of the backup for every repository.
+type Result struct {
+	Repos []RepoResult
+}
+
+// RepoResult describes the backup of a single repository.
+type RepoResult struct {
+	// Full name of the repository, for example "qvl/ghbackup"
+	Name  string
+	State State
+	// Number of times the backup has been tried
+	Attempts int
+	Duration time.Duration
+	// Number of bytes the mirror and exported metadata grew on disk
+	Bytes int64
+	// Number of bytes of LFS objects downloaded; included in Bytes
+	LFSBytes int64
+	// Error of the last attempt; nil if the backup succeeded
+	Err error
+}
+
+// Count returns the number of repositories with the given state.
+func (r Result) Count(s State) int {
+	n := 0
+	for _, repo := range r.Repos {
+		if repo.State == s {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary line printed at the end of a run.
+func (r Result) summary() string {
+	s := fmt.Sprintf(
+		"done: %d new, %d updated, %d unchanged, %d orphaned",
+		r.Count(StateNew),
+		r.Count(StateChanged),
+		r.Count(StateUnchanged),
+		r.Count(StateOrphaned),
+	)
+	if skipped := r.Count(StateSkipped); skipped > 0 {
+		s += fmt.Sprintf(", %d skipped", skipped)
+	}
+	var lfs int64
+	for _, repo := range r.Repos {
+		lfs += repo.LFSBytes
+	}
+	if lfs > 0 {
+		s += fmt.Sprintf(", %d bytes of LFS objects", lfs)
+	}
+	return s
+}
+
 type repo struct {
-	Path    string `json:"full_name"`
-	URL     string `json:"clone_url"`
-	Private bool   `json:"private"`
+	ID         int64     `json:"id"`
+	Path       string    `json:"full_name"`
+	URL        string    `json:"clone_url"`
+	SSHURL     string    `json:"ssh_url"`
+	Private    bool      `json:"private"`
+	Fork       bool      `json:"fork"`
+	Archived   bool      `json:"archived"`
+	Disabled   bool      `json:"disabled"`
+	Visibility string    `json:"visibility"`
+	Topics     []string  `json:"topics"`
+	Language   string    `json:"language"`
+	Size       int       `json:"size"`
+	PushedAt   time.Time `json:"pushed_at"`
+	HasWiki    bool      `json:"has_wiki"`
+	HasIssues  bool      `json:"has_issues"`
+	// Set for the wiki of a repository
+	wiki bool
+	// Set for gists
+	gist bool
+	// Set for repositories starred by the accounts
+	starred bool
+	// Provider of the account the repo belongs to; nil for GitHub
+	provider Provider
+	// Directory of the mirror if not derived from Path
+	dir string
+	// Credentials of the account the repo belongs to
+	auth authenticator
+	// API of the account if it differs from Config.API
+	api string
 }
 
 const defaultMaxWorkers = 10
 const defaultAPI = "https://api.github.com"
+
+var defaultRetries = []time.Duration{5 * time.Second, 15 * time.Second, 45 * time.Second, 90 * time.Second, 180 * time.Second}
@@ -0,0 +1,172 @@
+package ghbackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Gists are saved in this sub-directory of Config.Dir as ID.git.
+const gistDir = "gists"
+
+// File in the gist directory listing descriptions and file names of all gists.
+const gistIndexFile = "index.json"
+
+type gist struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+	Files       map[string]struct {
+		Filename string `json:"filename"`
+	} `json:"files"`
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	GitPullURL string    `json:"git_pull_url"`
+	HTMLURL    string    `json:"html_url"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Entry of the gist index file.
+type gistIndexEntry struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Description string    `json:"description"`
+	Public      bool      `json:"public"`
+	Files       []string  `json:"files"`
+	URL         string    `json:"url"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Get the gists of all accounts as repos to back up.
+// Without accounts, all gists of the authenticated user are returned.
+// With an account, its public gists are returned
+// and its secret gists if the secret belongs to the account.
+// An index of all gists is written to the gist directory.
+// Accounts not on GitHub are skipped.
+func (c Config) fetchGists(ctx context.Context) ([]repo, error) {
+	if c.App != nil {
+		return nil, errors.New("gists cannot be backed up with a GitHub App")
+	}
+
+	var all []gist
+	var repos []repo
+	seen := map[string]bool{}
+	// Add new gists of the account; all gists without account
+	add := func(gists []gist, auth authenticator, account string) {
+		for _, g := range gists {
+			if seen[g.ID] || account != "" && !strings.EqualFold(g.Owner.Login, account) {
+				continue
+			}
+			seen[g.ID] = true
+			all = append(all, g)
+			repos = append(repos, c.gistRepo(g, auth))
+		}
+	}
+
+	if len(c.Accounts) == 0 && c.provider(Account{}) == GitHub {
+		auth := secretAuth{secret: c.Secret}
+		gists, err := getGists(ctx, c.API+"/gists?per_page=100", auth, c.Doer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get gists: %v", err)
+		}
+		add(gists, auth, "")
+	}
+	for _, account := range c.Accounts {
+		if c.provider(account) != GitHub {
+			continue
+		}
+		secret := c.accountSecret(account)
+		auth := secretAuth{account: account.Name, secret: secret}
+		api := c.accountAPI(account)
+		urls := []string{api + "/users/" + account.Name + "/gists?per_page=100"}
+		if secret != "" {
+			// Only the gists of the authenticated user include secret gists
+			urls = append(urls, api+"/gists?per_page=100")
+		}
+		for _, u := range urls {
+			gists, err := getGists(ctx, u, auth, c.Doer)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get gists of %s: %v", account.Name, err)
+			}
+			add(gists, auth, account.Name)
+		}
+	}
+
+	if err := c.writeGistIndex(all); err != nil {
+		return nil, fmt.Errorf("cannot write gist index: %v", err)
+	}
+	return repos, nil
+}
+
+func getGists(ctx context.Context, listURL string, auth authenticator, doer Doer) ([]gist, error) {
+	items, err := getAll(ctx, listURL, auth, doer)
+	if err != nil {
+		return nil, err
+	}
+	gists := make([]gist, len(items))
+	for i, raw := range items {
+		if err := json.Unmarshal(raw, &gists[i]); err != nil {
+			return nil, fmt.Errorf("cannot decode gist: %v", err)
+		}
+	}
+	return gists, nil
+}
+
+// Get the repo to mirror the git remote of a gist.
+func (c Config) gistRepo(g gist, auth authenticator) repo {
+	return repo{
+		Path:   gistDir + "/" + g.ID,
+		URL:    g.GitPullURL,
+		SSHURL: gistSSHURL(g.GitPullURL),
+		gist:   true,
+		dir:    filepath.Join(c.Dir, gistDir, g.ID+".git"),
+		auth:   auth,
+	}
+}
+
+// Get the SSH URL of a gist from its HTTPS URL.
+// "https://gist.github.com/ID.git" becomes "git@gist.github.com:ID.git".
+func gistSSHURL(pullURL string) string {
+	u, err := url.Parse(pullURL)
+	if err != nil {
+		return ""
+	}
+	return "git@" + u.Host + ":" + strings.TrimPrefix(u.Path, "/")
+}
+
+// Write the description and file names of all gists to the index file.
+func (c Config) writeGistIndex(gists []gist) error {
+	dir := filepath.Join(c.Dir, gistDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	index := make([]gistIndexEntry, len(gists))
+	for i, g := range gists {
+		var files []string
+		for _, f := range g.Files {
+			files = append(files, f.Filename)
+		}
+		sort.Strings(files)
+		index[i] = gistIndexEntry{
+			ID:          g.ID,
+			Owner:       g.Owner.Login,
+			Description: g.Description,
+			Public:      g.Public,
+			Files:       files,
+			URL:         g.HTMLURL,
+			UpdatedAt:   g.UpdatedAt,
+		}
+	}
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].ID < index[j].ID
+	})
+	return writeJSONFile(filepath.Join(dir, gistIndexFile), index)
+}
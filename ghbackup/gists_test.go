@@ -0,0 +1,73 @@
+package ghbackup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Config_fetchGists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-gists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	public := `[{"id":"a1","description":"deploy","public":true,"owner":{"login":"qvl"},
+		"git_pull_url":"https://gist.github.com/a1.git","files":{"b.sh":{"filename":"b.sh"},"a.sh":{"filename":"a.sh"}}}]`
+	// Gists of the authenticated user include secret gists
+	own := `[
+		{"id":"a1","owner":{"login":"qvl"},"git_pull_url":"https://gist.github.com/a1.git"},
+		{"id":"b2","description":"secret","owner":{"login":"qvl"},"git_pull_url":"https://gist.github.com/b2.git"}
+	]`
+	c := Config{
+		Dir:      dir,
+		API:      "https://api.github.com",
+		Accounts: []Account{{Name: "qvl", Secret: "token"}},
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/users/qvl/gists":
+				return jsonResponse(req, 200, public), nil
+			case "/gists":
+				return jsonResponse(req, 200, own), nil
+			}
+			t.Errorf("unexpected request to %s", req.URL)
+			return jsonResponse(req, 404, ""), nil
+		}),
+	}
+
+	repos, err := c.fetchGists(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, r := range repos {
+		dirs = append(dirs, c.repoDir(r))
+	}
+	want := []string{filepath.Join(dir, "gists", "a1.git"), filepath.Join(dir, "gists", "b2.git")}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("dirs = %v, want %v", dirs, want)
+	}
+	if ssh := repos[0].SSHURL; ssh != "git@gist.github.com:a1.git" {
+		t.Errorf("unexpected SSH URL %s", ssh)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "gists", "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index []gistIndexEntry
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index[0].Description != "deploy" || !reflect.DeepEqual(index[0].Files, []string{"a.sh", "b.sh"}) {
+		t.Errorf("unexpected index: %s", b)
+	}
+}
@@ -0,0 +1,173 @@
+package ghbackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const defaultGitLabAPI = "https://gitlab.com/api/v4"
+
+// Query of all project lists.
+// Keyset pagination is faster for large groups; the next page is linked like with offset pagination.
+const gitLabListQuery = "per_page=100&pagination=keyset&order_by=id&sort=asc"
+
+// Lists projects with the GitLab v4 API.
+// Accounts are groups including their subgroups or users.
+type gitLabProvider struct{}
+
+func (gitLabProvider) String() string {
+	return "gitlab"
+}
+
+func (gitLabProvider) defaultAPI() string {
+	return defaultGitLabAPI
+}
+
+func (gitLabProvider) supportsExports() bool {
+	return false
+}
+
+func (gitLabProvider) repos(ctx context.Context, c Config, account Account) ([]repo, error) {
+	api := c.accountAPI(account)
+	auth := gitLabAuth{secret: c.accountSecret(account)}
+	u, err := gitLabProjectsURL(ctx, account.Name, auth, api, c.Doer)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := fetchGitLab(ctx, u, auth, c.Doer)
+	if err != nil {
+		return nil, err
+	}
+	var repos []repo
+	for _, p := range projects {
+		r := p.repo()
+		r.auth = auth
+		// Project IDs are only unique per GitLab instance
+		r.api = api
+		// With a single account, the path of a project in a subgroup is kept below the group
+		if len(c.Accounts) == 1 && account.Name != "" && hasPathPrefix(r.Path, account.Name) {
+			rel := r.Path[len(account.Name)+1:]
+			r.dir = filepath.Join(c.Dir, c.repoHost(r), filepath.FromSlash(rel)+".git")
+		}
+		repos = append(repos, r)
+	}
+	return repos, nil
+}
+
+// Authentication with a personal, group or project access token of GitLab.
+type gitLabAuth struct {
+	secret string
+}
+
+func (a gitLabAuth) authorize(req *http.Request) error {
+	if a.secret != "" {
+		req.Header.Set("PRIVATE-TOKEN", a.secret)
+	}
+	return nil
+}
+
+// GitLab accepts access tokens as password with any user name over HTTPS.
+func (a gitLabAuth) gitSecret(ctx context.Context) (string, error) {
+	return a.secret, nil
+}
+
+// A project as returned by the GitLab API.
+type gitLabProject struct {
+	ID                int64    `json:"id"`
+	PathWithNamespace string   `json:"path_with_namespace"`
+	HTTPURL           string   `json:"http_url_to_repo"`
+	SSHURL            string   `json:"ssh_url_to_repo"`
+	Visibility        string   `json:"visibility"`
+	Archived          bool     `json:"archived"`
+	Topics            []string `json:"topics"`
+	WikiEnabled       bool     `json:"wiki_enabled"`
+	IssuesEnabled     bool     `json:"issues_enabled"`
+	ForkedFromProject *struct {
+		ID int64 `json:"id"`
+	} `json:"forked_from_project"`
+}
+
+// Convert to the fields returned by the GitHub REST API.
+// GitLab lists neither the size nor the language of projects.
+// PushedAt is left empty since GitLab does not report the time of the last push;
+// last_activity_at is only updated about once an hour, so pushes could be missed.
+func (p gitLabProject) repo() repo {
+	return repo{
+		ID:         p.ID,
+		Path:       p.PathWithNamespace,
+		URL:        p.HTTPURL,
+		SSHURL:     p.SSHURL,
+		Private:    p.Visibility != "public",
+		Fork:       p.ForkedFromProject != nil,
+		Archived:   p.Archived,
+		Visibility: p.Visibility,
+		Topics:     p.Topics,
+		HasWiki:    p.WikiEnabled,
+		HasIssues:  p.IssuesEnabled,
+	}
+}
+
+// Get the URL listing the projects of a group or user.
+// Without account, the projects the authenticated user is a member of are listed.
+func gitLabProjectsURL(ctx context.Context, account string, auth authenticator, api string, doer Doer) (string, error) {
+	if account == "" {
+		return api + "/projects?membership=true&" + gitLabListQuery, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", api+"/groups/"+url.PathEscape(account), nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot create HTTP request: %v", err)
+	}
+	if err := auth.authorize(req); err != nil {
+		return "", err
+	}
+	res, err := doer.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot get group info: %v", err)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		return api + "/users/" + url.PathEscape(account) + "/projects?" + gitLabListQuery, nil
+	}
+	var group struct {
+		ID int64 `json:"id"`
+	}
+	if err := decodeResponse(res, &group); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/groups/%d/projects?include_subgroups=true&%s", api, group.ID, gitLabListQuery), nil
+}
+
+// Get all projects of a list starting at currentURL.
+// Follow all "next" links.
+func fetchGitLab(ctx context.Context, currentURL string, auth authenticator, doer Doer) ([]gitLabProject, error) {
+	var all []gitLabProject
+	for currentURL != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", currentURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create request: %v", err)
+		}
+		if err := auth.authorize(req); err != nil {
+			return nil, err
+		}
+		res, err := doer.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get projects: %v", err)
+		}
+		var projects []gitLabProject
+		if err := decodeResponse(res, &projects); err != nil {
+			return nil, err
+		}
+		all = append(all, projects...)
+		currentURL = getNextURL(res.Header)
+	}
+	return all, nil
+}
+
+// Check if a path is below a group, ignoring case like GitLab does.
+func hasPathPrefix(p, group string) bool {
+	return len(p) > len(group)+1 && strings.EqualFold(p[:len(group)+1], group+"/")
+}
@@ -0,0 +1,165 @@
+package ghbackup
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_gitLabProvider_repos(t *testing.T) {
+	const api = "https://gitlab.example.com/api/v4"
+	const next = api + "/groups/7/projects?include_subgroups=true&per_page=100&pagination=keyset&order_by=id&sort=asc&id_after=1"
+	var requests []string
+	c := Config{
+		Dir:      "/backup",
+		API:      api,
+		Provider: GitLab,
+		Accounts: []Account{{Name: "Team", API: api, Secret: "token"}},
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			requests = append(requests, req.URL.String())
+			if token := req.Header.Get("PRIVATE-TOKEN"); token != "token" {
+				t.Errorf("unexpected token %s", token)
+			}
+			switch req.URL.String() {
+			case api + "/groups/Team":
+				return jsonResponse(req, 200, `{"id":7}`), nil
+			case next:
+				return jsonResponse(req, 200, `[{"id":2,"path_with_namespace":"team/sub/tool",
+					"http_url_to_repo":"https://gitlab.example.com/team/sub/tool.git","visibility":"internal",
+					"forked_from_project":{"id":9}}]`), nil
+			default:
+				res := jsonResponse(req, 200, `[{"id":1,"path_with_namespace":"team/app",
+					"http_url_to_repo":"https://gitlab.example.com/team/app.git",
+					"ssh_url_to_repo":"git@gitlab.example.com:team/app.git","visibility":"public",
+					"archived":true,"topics":["backup"],"last_activity_at":"2020-01-02T00:00:00Z",
+					"wiki_enabled":true,"issues_enabled":true}]`)
+				res.Header.Set("Link", "<"+next+`>; rel="next"`)
+				return res, nil
+			}
+		}),
+	}
+
+	repos, err := c.fetchAccounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRequests := []string{
+		api + "/groups/Team",
+		api + "/groups/7/projects?include_subgroups=true&per_page=100&pagination=keyset&order_by=id&sort=asc",
+		next,
+	}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("requests = %v, want %v", requests, wantRequests)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("expected 2 repos; got %v", repos)
+	}
+	r := repos[0]
+	r.auth = nil
+	want := repo{
+		ID:         1,
+		Path:       "team/app",
+		URL:        "https://gitlab.example.com/team/app.git",
+		SSHURL:     "git@gitlab.example.com:team/app.git",
+		Visibility: "public",
+		Archived:   true,
+		Topics:     []string{"backup"},
+		HasWiki:    true,
+		HasIssues:  true,
+		api:        api,
+		provider:   GitLab,
+		dir:        filepath.Join("/backup", "app.git"),
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("repo = %+v, want %+v", r, want)
+	}
+	sub := repos[1]
+	if !sub.Private || !sub.Fork || sub.Visibility != "internal" {
+		t.Errorf("expected internal fork; got %+v", sub)
+	}
+	if dir := c.repoDir(sub); dir != filepath.Join("/backup", "sub", "tool.git") {
+		t.Errorf("unexpected dir %s", dir)
+	}
+	if dir := c.repoDir(wikiRepo(sub)); dir != filepath.Join("/backup", "sub", "tool.wiki.git") {
+		t.Errorf("unexpected wiki dir %s", dir)
+	}
+	if key := sub.idKey(); key != "gitlab.example.com/2" {
+		t.Errorf("unexpected ID key %s", key)
+	}
+
+	// Pushes are not reported, so projects are never skipped as unchanged
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	c.state = &runState{repos: map[string]RepoStatus{}}
+	c.state.update(r, RepoResult{Name: c.repoName(r)}, nil, false, now)
+	if unchanged, err := c.unchangedSincePush(r); err != nil || unchanged {
+		t.Errorf("expected GitLab project to be updated; got %v, %v", unchanged, err)
+	}
+
+	// Issues, pull requests and releases are only exported from GitHub
+	requests = nil
+	c.Issues, c.Pulls, c.Releases = true, true, true
+	if err := c.export(context.Background(), repos[0]); err != nil || len(requests) > 0 {
+		t.Errorf("expected no export of GitLab project; got %v after %v", err, requests)
+	}
+}
+
+func Test_gitLabProjectsURL(t *testing.T) {
+	const api = "https://gitlab.com/api/v4"
+	doer := doerFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(req, http.StatusNotFound, `{"message":"404 Group Not Found"}`), nil
+	})
+	tests := []struct {
+		account string
+		want    string
+	}{
+		{"", api + "/projects?membership=true&" + gitLabListQuery},
+		{"jorin", api + "/users/jorin/projects?" + gitLabListQuery},
+	}
+	for _, tt := range tests {
+		got, err := gitLabProjectsURL(context.Background(), tt.account, gitLabAuth{}, api, doer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("gitLabProjectsURL(%q) = %s, want %s", tt.account, got, tt.want)
+		}
+	}
+}
+
+func Test_ParseProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Provider
+		wantErr bool
+	}{
+		{"github", GitHub, false},
+		{"GitLab", GitLab, false},
+		{"bitbucket", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseProvider(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseProvider(%q) = %v, %v", tt.name, got, err)
+		}
+	}
+}
+
+func Test_accountAPI(t *testing.T) {
+	c := Config{API: "https://github.example.com/api/v3"}
+	tests := []struct {
+		account Account
+		want    string
+	}{
+		{Account{Name: "qvl"}, "https://github.example.com/api/v3"},
+		{Account{Name: "team", Provider: GitLab}, defaultGitLabAPI},
+		{Account{Name: "team", Provider: GitLab, API: "https://git.example.com/api/v4"}, "https://git.example.com/api/v4"},
+	}
+	for _, tt := range tests {
+		if got := c.accountAPI(tt.account); got != tt.want {
+			t.Errorf("accountAPI(%+v) = %s, want %s", tt.account, got, tt.want)
+		}
+	}
+}
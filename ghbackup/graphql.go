@@ -0,0 +1,189 @@
+package ghbackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Lists repositories with the GitHub GraphQL API.
+// All fields used by ghbackup are requested in a single query per 100 repositories.
+// GitHub Apps are listed with the REST API since installations have no repositories as viewer.
+type graphQLLister struct {
+	api  string
+	doer Doer
+}
+
+const graphQLRepos = `fragment repos on RepositoryConnection {
+  pageInfo { hasNextPage endCursor }
+  nodes {
+    databaseId nameWithOwner url sshUrl
+    isPrivate isFork isArchived isDisabled visibility
+    diskUsage pushedAt hasWikiEnabled hasIssuesEnabled
+    primaryLanguage { name }
+    repositoryTopics(first: 100) { nodes { topic { name } } }
+  }
+}`
+
+// Same affiliations as used by the REST API for /user/repos
+const graphQLViewerQuery = `query($cursor: String) {
+  owner: viewer {
+    repositories(first: 100, after: $cursor,
+      affiliations: [OWNER, COLLABORATOR, ORGANIZATION_MEMBER],
+      ownerAffiliations: [OWNER, COLLABORATOR, ORGANIZATION_MEMBER]) { ...repos }
+  }
+}
+` + graphQLRepos
+
+// Like the REST API for /users/NAME/repos, repositories of a user only include the ones it owns
+const graphQLOwnerQuery = `query($login: String!, $cursor: String) {
+  owner: repositoryOwner(login: $login) {
+    repositories(first: 100, after: $cursor, ownerAffiliations: [OWNER]) { ...repos }
+  }
+}
+` + graphQLRepos
+
+type graphQLRepo struct {
+	DatabaseID       int64     `json:"databaseId"`
+	NameWithOwner    string    `json:"nameWithOwner"`
+	URL              string    `json:"url"`
+	SSHURL           string    `json:"sshUrl"`
+	IsPrivate        bool      `json:"isPrivate"`
+	IsFork           bool      `json:"isFork"`
+	IsArchived       bool      `json:"isArchived"`
+	IsDisabled       bool      `json:"isDisabled"`
+	Visibility       string    `json:"visibility"`
+	DiskUsage        int       `json:"diskUsage"`
+	PushedAt         time.Time `json:"pushedAt"`
+	HasWikiEnabled   bool      `json:"hasWikiEnabled"`
+	HasIssuesEnabled bool      `json:"hasIssuesEnabled"`
+	PrimaryLanguage  *struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+}
+
+// Convert to the fields returned by the REST API.
+func (g graphQLRepo) repo() repo {
+	r := repo{
+		ID:         g.DatabaseID,
+		Path:       g.NameWithOwner,
+		URL:        g.URL + ".git",
+		SSHURL:     g.SSHURL,
+		Private:    g.IsPrivate,
+		Fork:       g.IsFork,
+		Archived:   g.IsArchived,
+		Disabled:   g.IsDisabled,
+		Visibility: strings.ToLower(g.Visibility),
+		Size:       g.DiskUsage,
+		PushedAt:   g.PushedAt,
+		HasWiki:    g.HasWikiEnabled,
+		HasIssues:  g.HasIssuesEnabled,
+	}
+	if g.PrimaryLanguage != nil {
+		r.Language = g.PrimaryLanguage.Name
+	}
+	for _, t := range g.RepositoryTopics.Nodes {
+		r.Topics = append(r.Topics, t.Topic.Name)
+	}
+	return r
+}
+
+func (l graphQLLister) list(ctx context.Context, account string, auth authenticator) ([]repo, error) {
+	var secret string
+	switch a := auth.(type) {
+	case *installationAuth:
+		return restLister(l).list(ctx, account, auth)
+	case secretAuth:
+		secret = a.secret
+	}
+	if secret == "" {
+		return nil, errors.New("the GraphQL API requires a secret")
+	}
+
+	query := graphQLOwnerQuery
+	variables := map[string]interface{}{"login": account}
+	if account == "" {
+		query = graphQLViewerQuery
+		variables = map[string]interface{}{}
+	}
+
+	var allRepos []repo
+	for {
+		var data struct {
+			Owner *struct {
+				Repositories struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []graphQLRepo `json:"nodes"`
+				} `json:"repositories"`
+			} `json:"owner"`
+		}
+		if err := l.query(ctx, secret, query, variables, &data); err != nil {
+			return nil, err
+		}
+		if data.Owner == nil {
+			return nil, fmt.Errorf("account %s not found", account)
+		}
+		for _, g := range data.Owner.Repositories.Nodes {
+			r := g.repo()
+			r.auth = auth
+			allRepos = append(allRepos, r)
+		}
+		page := data.Owner.Repositories.PageInfo
+		if !page.HasNextPage {
+			return selectRepos(allRepos, account), nil
+		}
+		variables["cursor"] = page.EndCursor
+	}
+}
+
+// Run a GraphQL query and decode its data into v.
+func (l graphQLLister) query(ctx context.Context, secret, query string, variables map[string]interface{}, v interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", graphQLURL(l.api), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %v", err)
+	}
+	req.Header.Set("Authorization", "bearer "+secret)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := l.doer.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot get repos: %v", err)
+	}
+	var out struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := decodeResponse(res, &out); err != nil {
+		return err
+	}
+	if len(out.Errors) > 0 {
+		return fmt.Errorf("GraphQL error: %s", out.Errors[0].Message)
+	}
+	return json.Unmarshal(out.Data, v)
+}
+
+// Get the GraphQL endpoint for a REST API URL.
+// GitHub Enterprise Server serves REST at /api/v3 and GraphQL at /api/graphql.
+func graphQLURL(api string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(api, "/"), "/v3") + "/graphql"
+}
@@ -0,0 +1,99 @@
+package ghbackup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_graphQLLister_list(t *testing.T) {
+	pages := []string{
+		`{"data":{"owner":{"repositories":{
+			"pageInfo":{"hasNextPage":true,"endCursor":"c1"},
+			"nodes":[{"databaseId":1,"nameWithOwner":"qvl/ghbackup","url":"https://github.com/qvl/ghbackup",
+				"sshUrl":"git@github.com:qvl/ghbackup.git","visibility":"PUBLIC","diskUsage":120,
+				"pushedAt":"2020-01-02T00:00:00Z","hasWikiEnabled":true,"primaryLanguage":{"name":"Go"},
+				"repositoryTopics":{"nodes":[{"topic":{"name":"backup"}}]}}]}}}}`,
+		`{"data":{"owner":{"repositories":{
+			"pageInfo":{"hasNextPage":false},
+			"nodes":[{"databaseId":2,"nameWithOwner":"qvl/private","url":"https://github.com/qvl/private","isPrivate":true,"visibility":"PRIVATE"},
+				{"databaseId":3,"nameWithOwner":"other/tool","url":"https://github.com/other/tool"}]}}}}`,
+	}
+	var cursors []interface{}
+	l := graphQLLister{
+		api: "https://github.example.com/api/v3",
+		doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.String() != "https://github.example.com/api/graphql" {
+				t.Errorf("unexpected request to %s", req.URL)
+			}
+			if auth := req.Header.Get("Authorization"); auth != "bearer token" {
+				t.Errorf("unexpected authorization %s", auth)
+			}
+			var body struct {
+				Query     string
+				Variables map[string]interface{}
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(body.Query, "ownerAffiliations: [OWNER]") {
+				t.Errorf("expected only owned repositories to be queried")
+			}
+			if body.Variables["login"] != "qvl" {
+				t.Errorf("unexpected variables %v", body.Variables)
+			}
+			cursors = append(cursors, body.Variables["cursor"])
+			return jsonResponse(req, 200, pages[len(cursors)-1]), nil
+		}),
+	}
+
+	repos, err := l.list(context.Background(), "qvl", secretAuth{account: "qvl", secret: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{nil, "c1"}; !reflect.DeepEqual(cursors, want) {
+		t.Errorf("cursors = %v, want %v", cursors, want)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("expected 2 repos; got %v", repos)
+	}
+	r := repos[0]
+	r.auth = nil
+	want := repo{
+		ID:         1,
+		Path:       "qvl/ghbackup",
+		URL:        "https://github.com/qvl/ghbackup.git",
+		SSHURL:     "git@github.com:qvl/ghbackup.git",
+		Visibility: "public",
+		Topics:     []string{"backup"},
+		Language:   "Go",
+		Size:       120,
+		PushedAt:   time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		HasWiki:    true,
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("repo = %+v, want %+v", r, want)
+	}
+	if !repos[1].Private || repos[1].Visibility != "private" {
+		t.Errorf("expected private repo; got %+v", repos[1])
+	}
+}
+
+func Test_graphQLLister_list_errors(t *testing.T) {
+	l := graphQLLister{
+		api: "https://api.github.com",
+		doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			return jsonResponse(req, 200, `{"data":{"owner":null},"errors":[{"message":"Could not resolve to a RepositoryOwner"}]}`), nil
+		}),
+	}
+	if _, err := l.list(context.Background(), "missing", secretAuth{secret: "token"}); err == nil {
+		t.Error("expected error for GraphQL errors")
+	}
+	if _, err := l.list(context.Background(), "qvl", secretAuth{account: "qvl"}); err == nil {
+		t.Error("expected error without secret")
+	}
+}
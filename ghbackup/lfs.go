@@ -0,0 +1,46 @@
+package ghbackup
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// Check that the git-lfs extension is installed.
+func checkLFS(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "git", "lfs", "version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git-lfs is required to back up LFS objects: %s (%v)", out, err)
+	}
+	return nil
+}
+
+// Check if the LFS objects of a repo are fetched.
+// Wikis, gists and starred repos are only mirrored with git.
+func (c Config) fetchesLFS(r repo) bool {
+	return c.LFS && !r.wiki && !r.gist && !r.starred
+}
+
+// Fetch the LFS objects of all refs into the mirror of a repo.
+// Objects are saved by git-lfs in the lfs directory of the mirror.
+// Like other git commands, the fetch is not aborted when the context is canceled.
+func (c Config) fetchLFS(ctx context.Context, r repo) error {
+	ctx = detachedContext{ctx}
+	cmd := exec.CommandContext(ctx, "git", "lfs", "fetch", "--all", "origin")
+	cmd.Dir = c.repoDir(r)
+	env, secret, err := c.gitEnv(ctx, r)
+	if err != nil {
+		return err
+	}
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot fetch LFS objects of %s: %s (%v)", r.Path, maskSecrets([]string{string(out)}, []string{secret})[0], err)
+	}
+	return nil
+}
+
+// Get the directory git-lfs stores objects of a mirror in.
+func (c Config) lfsDir(r repo) string {
+	return filepath.Join(c.repoDir(r), "lfs", "objects")
+}
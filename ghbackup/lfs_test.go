@@ -0,0 +1,77 @@
+package ghbackup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Config_fetchLFS(t *testing.T) {
+	if err := exec.Command("git", "lfs", "version").Run(); err != nil {
+		t.Skip("git-lfs is not installed")
+	}
+	dir, err := ioutil.TempDir("", "ghbackup-lfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// A mirror referencing an LFS object whose origin is gone
+	c := Config{Dir: dir, Accounts: []Account{{Name: "qvl"}}, LFS: true}
+	r := repo{Path: "qvl/assets", URL: "file://" + filepath.Join(dir, "missing.git")}
+	repoDir := c.repoDir(r)
+	git := func(stdin string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s (%v)", args, out, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("", "init", "--bare")
+	git("", "remote", "add", "origin", r.URL)
+	pointer := git("version https://git-lfs.github.com/spec/v1\n"+
+		"oid sha256:"+strings.Repeat("a", 64)+"\n"+
+		"size 12\n", "hash-object", "-w", "--stdin")
+	tree := git("100644 blob "+pointer+"\tlogo.png\n", "mktree")
+	commit := git("", "-c", "user.name=ghbackup", "-c", "user.email=ghbackup@example.com", "commit-tree", tree, "-m", "Add logo")
+	git("", "update-ref", "refs/heads/master", commit)
+
+	err = c.fetchLFS(context.Background(), r)
+	if err == nil || !strings.Contains(err.Error(), "cannot fetch LFS objects of qvl/assets") {
+		t.Errorf("expected fetch of LFS objects to fail; got %v", err)
+	}
+}
+
+func Test_Config_fetchesLFS(t *testing.T) {
+	tests := []struct {
+		name string
+		lfs  bool
+		r    repo
+		want bool
+	}{
+		{"disabled", false, repo{Path: "qvl/ghbackup"}, false},
+		{"repo", true, repo{Path: "qvl/ghbackup"}, true},
+		{"wiki", true, repo{Path: "qvl/ghbackup.wiki", wiki: true}, false},
+		{"gist", true, repo{Path: "qvl/gist", gist: true}, false},
+		{"starred", true, repo{Path: "qvl/starred", starred: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Config{LFS: tt.lfs}).fetchesLFS(tt.r); got != tt.want {
+				t.Errorf("fetchesLFS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
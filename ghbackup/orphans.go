@@ -0,0 +1,190 @@
+package ghbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Policies for mirrors of repositories that are no longer listed by GitHub.
+const (
+	// Only report orphaned mirrors
+	OrphansKeep = "keep"
+	// Move orphaned mirrors to Dir/_archive/DATE
+	OrphansArchive = "archive"
+	// Delete orphaned mirrors after Config.OrphanDays
+	OrphansDelete = "delete"
+)
+
+// Orphaned mirrors are moved to this sub-directory of Config.Dir.
+const archiveDir = "_archive"
+
+// File in Config.Dir storing when mirrors have been found orphaned first.
+const orphanFile = "orphans.json"
+
+func validOrphanPolicy(p string) bool {
+	return p == "" || p == OrphansKeep || p == OrphansArchive || p == OrphansDelete
+}
+
+// Find mirrors in Dir that do not belong to any of the listed repos and handle them according to the orphan policy.
+// Listed repos include the ones skipped by filters; they still exist on GitHub.
+// Metadata directories of orphaned mirrors are handled the same way.
+func (c Config) handleOrphans(listed []repo, now time.Time) ([]RepoResult, error) {
+	known := map[string]bool{}
+	for _, r := range listed {
+		known[c.repoDir(r)] = true
+	}
+	mirrors, err := c.findMirrors()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find orphaned repositories: %v", err)
+	}
+	var orphans []string
+	for _, dir := range mirrors {
+		if !known[dir] {
+			orphans = append(orphans, dir)
+		}
+	}
+
+	firstSeen, err := c.readOrphans()
+	if err != nil {
+		return nil, err
+	}
+	// Only keep orphans that are still missing; reappearing repos are forgotten
+	seen := map[string]time.Time{}
+	var results []RepoResult
+	for _, dir := range orphans {
+		rel, err := filepath.Rel(c.Dir, dir)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".git")
+		since, ok := firstSeen[filepath.ToSlash(rel)]
+		if !ok {
+			since = now
+		}
+		results = append(results, RepoResult{Name: name, State: StateOrphaned})
+
+		if len(listed) == 0 {
+			// Most likely the credentials lost access; do not touch anything
+			c.Log.Printf("Orphaned %s (no repositories listed, keeping it)", name)
+			seen[filepath.ToSlash(rel)] = since
+			continue
+		}
+		switch c.Orphans {
+		case OrphansArchive:
+			target := filepath.Join(c.Dir, archiveDir, now.Format("2006-01-02"), rel)
+			c.Log.Printf("Orphaned %s (archiving to %s)", name, target)
+			if err := moveMirror(dir, target); err != nil {
+				return results, fmt.Errorf("cannot archive %s: %v", name, err)
+			}
+		case OrphansDelete:
+			if deleteAt := since.AddDate(0, 0, c.OrphanDays); now.Before(deleteAt) {
+				c.Log.Printf("Orphaned %s (deleting after %s)", name, deleteAt.Format("2006-01-02"))
+				seen[filepath.ToSlash(rel)] = since
+				continue
+			}
+			c.Log.Printf("Orphaned %s (deleting)", name)
+			if err := removeMirror(dir); err != nil {
+				return results, fmt.Errorf("cannot delete %s: %v", name, err)
+			}
+		default:
+			c.Log.Printf("Orphaned %s (not listed since %s)", name, since.Format("2006-01-02"))
+			seen[filepath.ToSlash(rel)] = since
+		}
+	}
+	if len(seen) == 0 && len(firstSeen) == 0 {
+		return results, nil
+	}
+	return results, writeJSONFile(filepath.Join(c.Dir, orphanFile), seen)
+}
+
+// Find the directories of all mirrors in Dir.
+// Collections that are not enabled and the archive are ignored.
+func (c Config) findMirrors() ([]string, error) {
+	var mirrors []string
+	root := filepath.Clean(c.Dir)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() || p == root {
+			return nil
+		}
+		if filepath.Dir(p) == root {
+			switch info.Name() {
+			case archiveDir:
+				return filepath.SkipDir
+			case gistDir:
+				if !c.Gists {
+					return filepath.SkipDir
+				}
+			case starredDir:
+				if !c.Starred {
+					return filepath.SkipDir
+				}
+			}
+		}
+		if strings.HasSuffix(p, ".meta") {
+			return filepath.SkipDir
+		}
+		if strings.HasSuffix(p, ".git") {
+			if c.Wikis || !strings.HasSuffix(p, ".wiki.git") {
+				mirrors = append(mirrors, p)
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return mirrors, err
+}
+
+// Get the time each orphaned mirror has been found first by its path relative to Dir.
+func (c Config) readOrphans() (map[string]time.Time, error) {
+	orphans := map[string]time.Time{}
+	b, err := ioutil.ReadFile(filepath.Join(c.Dir, orphanFile))
+	if os.IsNotExist(err) {
+		return orphans, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &orphans); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", orphanFile, err)
+	}
+	return orphans, nil
+}
+
+// Get the metadata directory next to a mirror directory.
+func metaDirOf(dir string) string {
+	return strings.TrimSuffix(dir, ".git") + ".meta"
+}
+
+// Move a mirror and its metadata.
+func moveMirror(dir, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, target); err != nil {
+		return err
+	}
+	meta := metaDirOf(dir)
+	if ok, err := exists(meta); err != nil || !ok {
+		return err
+	}
+	return os.Rename(meta, metaDirOf(target))
+}
+
+// Remove a mirror and its metadata.
+func removeMirror(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(metaDirOf(dir))
+}
@@ -0,0 +1,118 @@
+package ghbackup
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_Config_handleOrphans(t *testing.T) {
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		policy  string
+		days    int
+		listed  []repo
+		orphans []string
+		// Mirrors left in place after handling orphans
+		want []string
+	}{
+		{
+			name:    "keep",
+			policy:  OrphansKeep,
+			listed:  []repo{{Path: "qvl/ghbackup"}},
+			orphans: []string{"qvl/deleted", "qvl/removed"},
+			want:    []string{"qvl/deleted.git", "qvl/ghbackup.git", "qvl/removed.git"},
+		},
+		{
+			name:    "archive",
+			policy:  OrphansArchive,
+			listed:  []repo{{Path: "qvl/ghbackup"}, {Path: "qvl/removed"}},
+			orphans: []string{"qvl/deleted"},
+			want:    []string{"_archive/2020-01-10/qvl/deleted.git", "qvl/ghbackup.git", "qvl/removed.git"},
+		},
+		{
+			name:    "delete",
+			policy:  OrphansDelete,
+			listed:  []repo{{Path: "qvl/ghbackup"}},
+			orphans: []string{"qvl/deleted", "qvl/removed"},
+			want:    []string{"qvl/ghbackup.git"},
+		},
+		{
+			name:    "delete later",
+			policy:  OrphansDelete,
+			days:    30,
+			listed:  []repo{{Path: "qvl/ghbackup"}},
+			orphans: []string{"qvl/deleted", "qvl/removed"},
+			want:    []string{"qvl/deleted.git", "qvl/ghbackup.git", "qvl/removed.git"},
+		},
+		{
+			name:    "nothing listed",
+			policy:  OrphansDelete,
+			orphans: []string{"qvl/deleted", "qvl/ghbackup", "qvl/removed"},
+			want:    []string{"qvl/deleted.git", "qvl/ghbackup.git", "qvl/removed.git"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "ghbackup-orphans")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(dir)
+			}()
+			for _, m := range []string{"qvl/ghbackup.git", "qvl/deleted.git", "qvl/removed.git", "qvl/deleted.meta"} {
+				if err := os.MkdirAll(filepath.Join(dir, m), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := Config{
+				Dir:        dir,
+				Accounts:   []Account{{Name: "qvl"}, {Name: "jorinvo"}},
+				Orphans:    tt.policy,
+				OrphanDays: tt.days,
+				Log:        log.New(ioutil.Discard, "", 0),
+			}
+			results, err := c.handleOrphans(tt.listed, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var orphans []string
+			for _, r := range results {
+				if r.State != StateOrphaned {
+					t.Errorf("unexpected state %v of %s", r.State, r.Name)
+				}
+				orphans = append(orphans, r.Name)
+			}
+			sort.Strings(orphans)
+			if !reflect.DeepEqual(orphans, tt.orphans) {
+				t.Errorf("orphans = %v, want %v", orphans, tt.orphans)
+			}
+
+			left, err := filepath.Glob(filepath.Join(dir, "*", "*.git"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			archived, err := filepath.Glob(filepath.Join(dir, archiveDir, "*", "*", "*.git"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range append(archived, left...) {
+				rel, _ := filepath.Rel(dir, m)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mirrors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
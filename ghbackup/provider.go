@@ -0,0 +1,103 @@
+package ghbackup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Provider is a service hosting repositories to back up.
+// Mirrors are created the same way for all providers
+// but exports, gists, starred repositories and Apps are only supported for GitHub.
+// The interface is closed: its unexported methods depend on the internals of the backup,
+// so GitHub and GitLab are the only implementations.
+type Provider interface {
+	// Name of the provider as accepted by ParseProvider
+	String() string
+	// API used if neither Config.API nor Account.API is set
+	defaultAPI() string
+	// Reports if issues, pull requests and releases can be exported
+	supportsExports() bool
+	// Get the repositories of an account.
+	// Without account name, all repositories accessible with the secret are returned.
+	repos(ctx context.Context, c Config, account Account) ([]repo, error)
+}
+
+var (
+	// GitHub and GitHub Enterprise Server
+	GitHub Provider = gitHubProvider{}
+	// GitLab.com and self-managed GitLab
+	GitLab Provider = gitLabProvider{}
+)
+
+// ParseProvider returns the provider with the given name: "github" or "gitlab".
+func ParseProvider(name string) (Provider, error) {
+	for _, p := range []Provider{GitHub, GitLab} {
+		if strings.EqualFold(name, p.String()) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported provider %s", name)
+}
+
+// Get the provider of an account.
+// Without account name, the provider of Config is returned.
+func (c Config) provider(a Account) Provider {
+	if a.Provider != nil {
+		return a.Provider
+	}
+	if c.Provider != nil {
+		return c.Provider
+	}
+	return GitHub
+}
+
+// Get the provider of a repo.
+// Repos of GitHub Apps, gists and starred repos are always on GitHub.
+func (r repo) host() Provider {
+	if r.provider != nil {
+		return r.provider
+	}
+	return GitHub
+}
+
+// Get the secret of an account.
+func (c Config) accountSecret(a Account) string {
+	if a.Secret != "" {
+		return a.Secret
+	}
+	return c.Secret
+}
+
+type gitHubProvider struct{}
+
+func (gitHubProvider) String() string {
+	return "github"
+}
+
+func (gitHubProvider) defaultAPI() string {
+	return defaultAPI
+}
+
+func (gitHubProvider) supportsExports() bool {
+	return true
+}
+
+func (gitHubProvider) repos(ctx context.Context, c Config, account Account) ([]repo, error) {
+	auth := secretAuth{account: account.Name, secret: c.accountSecret(account)}
+	if account.Name == "" {
+		return c.lister().list(ctx, "", auth)
+	}
+	ac := c
+	ac.API = c.accountAPI(account)
+	repos, err := ac.lister().list(ctx, account.Name, auth)
+	if err != nil {
+		return nil, err
+	}
+	for i := range repos {
+		if account.API != "" || ac.API != c.API {
+			repos[i].api = ac.API
+		}
+	}
+	return repos, nil
+}
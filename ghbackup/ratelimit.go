@@ -0,0 +1,153 @@
+package ghbackup
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Doer that handles rate limits and temporary errors of the GitHub API.
+// When the rate limit is exceeded, it waits until the limit is reset.
+// Server errors are retried with exponential backoff and jitter.
+// Responses are returned unchanged if waiting would exceed the deadline of the request.
+type rateLimitDoer struct {
+	doer Doer
+	log  *log.Logger
+	// Base duration for exponential backoff
+	backoff time.Duration
+
+	mu sync.Mutex
+	// Reset time of the rate limit window the low quota has been logged for
+	warned time.Time
+}
+
+const (
+	// Attempts for a single request before the last response is returned
+	maxAttempts = 6
+	// Wait after a secondary rate limit without Retry-After header as documented by GitHub
+	secondaryRateLimitWait = time.Minute
+	// Log a warning if less than this fraction of the rate limit is remaining
+	lowRateLimit = 0.1
+)
+
+func newRateLimitDoer(doer Doer, logger *log.Logger) *rateLimitDoer {
+	return &rateLimitDoer{doer: doer, log: logger, backoff: time.Second}
+}
+
+func (d *rateLimitDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		res, err := d.doer.Do(req)
+		if err != nil {
+			return res, err
+		}
+		d.checkQuota(res.Header)
+
+		wait, retry := d.retryAfter(res, attempt)
+		if !retry || attempt >= maxAttempts {
+			return res, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return res, nil
+		}
+		// Body can only be replayed if it can be recreated
+		if req.Body != nil && req.GetBody == nil {
+			return res, nil
+		}
+
+		_, _ = ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		d.log.Printf("%s from %s, retrying in %v", res.Status, req.URL.Host+req.URL.Path, wait.Round(time.Second))
+		if !sleep(ctx, wait) {
+			return nil, ctx.Err()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("cannot reset request body: %v", err)
+			}
+		}
+	}
+}
+
+// Get the duration to wait before retrying a request.
+// Returns false if the response should not be retried.
+func (d *rateLimitDoer) retryAfter(res *http.Response, attempt int) (time.Duration, bool) {
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusForbidden {
+		if s := res.Header.Get("Retry-After"); s != "" {
+			if seconds, err := strconv.Atoi(s); err == nil {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+		if res.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, ok := rateLimitReset(res.Header); ok {
+				// Wait a second longer to avoid clock differences
+				return time.Until(reset) + time.Second, true
+			}
+		}
+		if res.StatusCode == http.StatusForbidden {
+			if isSecondaryRateLimit(res) {
+				return secondaryRateLimitWait, true
+			}
+			// Missing permissions
+			return 0, false
+		}
+		return d.jitter(attempt), true
+	}
+	if res.StatusCode >= 500 {
+		return d.jitter(attempt), true
+	}
+	return 0, false
+}
+
+// Exponential backoff with jitter.
+func (d *rateLimitDoer) jitter(attempt int) time.Duration {
+	max := d.backoff << uint(attempt)
+	return max/2 + time.Duration(rand.Int63n(int64(max/2)+1))
+}
+
+// Secondary rate limits are only reported in the message of the response.
+// The body is restored after reading it.
+func isSecondaryRateLimit(res *http.Response) bool {
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return err == nil && strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
+
+func rateLimitReset(h http.Header) (time.Time, bool) {
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(reset, 0), true
+}
+
+// Log once per rate limit window when the remaining quota is low.
+func (d *rateLimitDoer) checkQuota(h http.Header) {
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, err := strconv.Atoi(h.Get("X-RateLimit-Limit"))
+	if err != nil || float64(remaining) >= float64(limit)*lowRateLimit {
+		return
+	}
+	reset, ok := rateLimitReset(h)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.warned.Equal(reset) {
+		return
+	}
+	d.warned = reset
+	d.log.Printf("GitHub API rate limit low: %d of %d requests remaining until %s", remaining, limit, reset.Format(time.Kitchen))
+}
@@ -0,0 +1,127 @@
+package ghbackup
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_rateLimitDoer_Do(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []*http.Response
+		want      int
+		requests  int
+	}{
+		{
+			name:      "success",
+			responses: []*http.Response{{StatusCode: 200}},
+			want:      200,
+			requests:  1,
+		},
+		{
+			name:      "server error",
+			responses: []*http.Response{{StatusCode: 502}, {StatusCode: 500}, {StatusCode: 200}},
+			want:      200,
+			requests:  3,
+		},
+		{
+			name: "rate limit",
+			responses: []*http.Response{
+				{StatusCode: 403, Header: http.Header{
+					"X-Ratelimit-Remaining": {"0"},
+					"X-Ratelimit-Reset":     {strconv.FormatInt(time.Now().Unix()-1, 10)},
+				}},
+				{StatusCode: 200},
+			},
+			want:     200,
+			requests: 2,
+		},
+		{
+			name:      "retry after",
+			responses: []*http.Response{{StatusCode: 429, Header: http.Header{"Retry-After": {"0"}}}, {StatusCode: 200}},
+			want:      200,
+			requests:  2,
+		},
+		{
+			name:      "forbidden",
+			responses: []*http.Response{{StatusCode: 403}, {StatusCode: 200}},
+			want:      403,
+			requests:  1,
+		},
+		{
+			name:      "not found",
+			responses: []*http.Response{{StatusCode: 404}, {StatusCode: 200}},
+			want:      404,
+			requests:  1,
+		},
+		{
+			name: "gives up",
+			responses: []*http.Response{
+				{StatusCode: 500}, {StatusCode: 500}, {StatusCode: 500},
+				{StatusCode: 500}, {StatusCode: 500}, {StatusCode: 500},
+				{StatusCode: 200},
+			},
+			want:     500,
+			requests: maxAttempts,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			d := newRateLimitDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+				res := tt.responses[requests]
+				requests++
+				if res.Header == nil {
+					res.Header = http.Header{}
+				}
+				res.Body = ioutil.NopCloser(&bytes.Buffer{})
+				res.Request = req
+				return res, nil
+			}), log.New(ioutil.Discard, "", 0))
+			d.backoff = time.Millisecond
+
+			req, err := http.NewRequest("GET", "https://api.github.com/user/repos", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := d.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.want {
+				t.Errorf("expected status %d; got %d", tt.want, res.StatusCode)
+			}
+			if requests != tt.requests {
+				t.Errorf("expected %d requests; got %d", tt.requests, requests)
+			}
+		})
+	}
+}
+
+func Test_rateLimitDoer_Do_deadline(t *testing.T) {
+	d := newRateLimitDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		res := jsonResponse(req, http.StatusForbidden, "")
+		res.Header.Set("Retry-After", "3600")
+		return res, nil
+	}), log.New(ioutil.Discard, "", 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user/repos", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := d.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected response to be returned without waiting; got %d", res.StatusCode)
+	}
+}
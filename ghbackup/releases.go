@@ -0,0 +1,171 @@
+package ghbackup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Number of assets of a repository downloaded in parallel
+const assetWorkers = 4
+
+type release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []asset `json:"assets"`
+}
+
+type asset struct {
+	Name string `json:"name"`
+	// API URL to download the asset
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// Like "sha256:HEX"; not set for old assets
+	Digest string `json:"digest"`
+}
+
+// Export releases to releases.json and download all assets to releases/TAG/NAME.
+// Assets already downloaded with matching size and digest are skipped.
+func (c Config) exportReleases(ctx context.Context, r repo, dir string) error {
+	items, err := getAll(ctx, c.repoAPI(r)+"/repos/"+r.Path+"/releases?per_page=100", r.auth, c.Doer)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(dir, "releases.json"), items); err != nil {
+		return err
+	}
+
+	type download struct {
+		asset asset
+		file  string
+	}
+	var downloads []download
+	for _, raw := range items {
+		var rel release
+		if err := json.Unmarshal(raw, &rel); err != nil {
+			return fmt.Errorf("cannot decode release: %v", err)
+		}
+		relDir := filepath.Join(dir, "releases", safeName(rel.TagName))
+		for _, a := range rel.Assets {
+			file := filepath.Join(relDir, safeName(a.Name))
+			ok, err := hasAsset(file, a)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				downloads = append(downloads, download{asset: a, file: file})
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	each(ctx, len(downloads), assetWorkers, func(i int) {
+		d := downloads[i]
+		err := c.downloadAsset(ctx, r, d.asset, d.file)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("cannot download %s: %v", d.asset.Name, err)
+		}
+	})
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Replace characters that cannot be used in a single path element.
+func safeName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_" + name
+	}
+	return name
+}
+
+// Check if an asset has already been downloaded.
+func hasAsset(file string, a asset) (bool, error) {
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != a.Size {
+		return false, nil
+	}
+	if a.Digest == "" {
+		return true, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return a.Digest == "sha256:"+hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Download an asset and verify its size and digest.
+// The file is only replaced after a successful download.
+func (c Config) downloadAsset(ctx context.Context, r repo, a asset, file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", a.URL, nil)
+	if err != nil {
+		return fmt.Errorf("cannot create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	if r.auth != nil {
+		if err := r.auth.authorize(req); err != nil {
+			return err
+		}
+	}
+	res, err := c.Doer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("bad response from %s: %v", res.Request.URL, res.Status)
+	}
+
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), res.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n != a.Size {
+		err = fmt.Errorf("expected %d bytes; got %d", a.Size, n)
+	}
+	if digest := "sha256:" + hex.EncodeToString(h.Sum(nil)); err == nil && a.Digest != "" && a.Digest != digest {
+		err = fmt.Errorf("expected digest %s; got %s", a.Digest, digest)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,84 @@
+package ghbackup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func Test_Config_exportReleases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	content := "binary"
+	sum := sha256.Sum256([]byte(content))
+	releases := fmt.Sprintf(`[{"id":1,"tag_name":"v1.0","assets":[
+		{"name":"ghbackup.tar.gz","size":%d,"digest":"sha256:%s","url":"https://api.github.com/repos/qvl/ghbackup/releases/assets/3"},
+		{"name":"../checksums","size":%d,"url":"https://api.github.com/repos/qvl/ghbackup/releases/assets/4"}
+	]}]`, len(content), hex.EncodeToString(sum[:]), len(content))
+
+	var mu sync.Mutex
+	downloads := 0
+	c := Config{
+		Dir:      dir,
+		API:      "https://api.github.com",
+		Accounts: []Account{{Name: "qvl"}},
+		Releases: true,
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/repos/qvl/ghbackup/releases":
+				return jsonResponse(req, 200, releases), nil
+			case "/repos/qvl/ghbackup/releases/assets/3", "/repos/qvl/ghbackup/releases/assets/4":
+				if accept := req.Header.Get("Accept"); accept != "application/octet-stream" {
+					t.Errorf("expected binary download; got Accept %s", accept)
+				}
+				mu.Lock()
+				downloads++
+				mu.Unlock()
+				return jsonResponse(req, 200, content), nil
+			}
+			t.Errorf("unexpected request to %s", req.URL)
+			return jsonResponse(req, 404, ""), nil
+		}),
+	}
+	r := repo{Path: "qvl/ghbackup"}
+
+	for i := 0; i < 2; i++ {
+		if err := c.export(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if downloads != 2 {
+		t.Errorf("expected each asset to be downloaded once; got %d downloads", downloads)
+	}
+	for _, f := range []string{"releases.json", "releases/v1.0/ghbackup.tar.gz", "releases/v1.0/.._checksums"} {
+		if _, err := os.Stat(filepath.Join(dir, "ghbackup.meta", f)); err != nil {
+			t.Errorf("expected %s to be exported: %v", f, err)
+		}
+	}
+
+	// Corrupted downloads are not kept
+	content = "broken"
+	file := filepath.Join(dir, "ghbackup.meta", "releases", "v1.0", "ghbackup.tar.gz")
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.export(context.Background(), r); err == nil {
+		t.Error("expected error for wrong digest")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("expected no file for corrupted download; got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package ghbackup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Key in the git config of a mirror storing the ID of its repository.
+const idConfigKey = "ghbackup.id"
+
+// Get the value identifying a repo in the git config of its mirror.
+// Wikis and starred repos share the ID of a repository and get a different key.
+// Returns an empty string for repos without ID like gists; they are identified by their path already.
+func (r repo) idKey() string {
+	if r.ID == 0 || r.gist {
+		return ""
+	}
+	key := strconv.FormatInt(r.ID, 10)
+	if r.wiki {
+		key += ".wiki"
+	}
+	if r.starred {
+		key = starredDir + "/" + key
+	}
+	// IDs are only unique per GitHub or GitLab instance
+	if u, err := url.Parse(r.api); r.api != "" && err == nil {
+		key = u.Host + "/" + key
+	}
+	return key
+}
+
+// Save the ID of a repo in the git config of its mirror.
+func (c Config) setRepoID(ctx context.Context, r repo) error {
+	key := r.idKey()
+	if key == "" {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "config", idConfigKey, key)
+	cmd.Dir = c.repoDir(r)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot save ID of %s: %s (%v)", r.Path, out, err)
+	}
+	return nil
+}
+
+// Get the repo ID saved in the git config of a mirror.
+// Returns an empty string for mirrors of older versions without ID.
+func getRepoID(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", idConfigKey)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// Key is not set
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot get ID of mirror %s: %v", dir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Move mirrors of renamed and transferred repositories to their new path.
+// Mirrors not belonging to a listed repo are matched by the ID in their git config.
+// A mirror is only moved if there is no mirror at the new path yet.
+func (c Config) moveRenamed(ctx context.Context, listed []repo) error {
+	byID := map[string]repo{}
+	known := map[string]bool{}
+	for _, r := range listed {
+		known[c.repoDir(r)] = true
+		if key := r.idKey(); key != "" {
+			byID[key] = r
+		}
+	}
+	mirrors, err := c.findMirrors()
+	if err != nil {
+		return fmt.Errorf("cannot find renamed repositories: %v", err)
+	}
+	for _, dir := range mirrors {
+		if known[dir] {
+			continue
+		}
+		key, err := getRepoID(ctx, dir)
+		if err != nil {
+			return err
+		}
+		r, ok := byID[key]
+		if key == "" || !ok {
+			continue
+		}
+		target := c.repoDir(r)
+		targetExists, err := exists(target)
+		if err != nil {
+			return err
+		}
+		if targetExists {
+			continue
+		}
+		c.Log.Printf("Moving %s to %s (renamed or transferred)", dir, target)
+		if err := moveMirror(dir, target); err != nil {
+			return fmt.Errorf("cannot move mirror of %s: %v", r.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package ghbackup
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Config_moveRenamed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-rename")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	c := Config{
+		Dir:      dir,
+		Accounts: []Account{{Name: "qvl"}, {Name: "jorinvo"}},
+		Log:      log.New(ioutil.Discard, "", 0),
+	}
+	ctx := context.Background()
+	old := repo{ID: 42, Path: "qvl/old-name"}
+	other := repo{ID: 7, Path: "qvl/other"}
+	for _, r := range []repo{old, other} {
+		if out, err := exec.Command("git", "init", "--bare", c.repoDir(r)).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v", out, err)
+		}
+		if err := c.setRepoID(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(c.metaDir(old), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := repo{ID: 42, Path: "jorinvo/new-name"}
+	if err := c.moveRenamed(ctx, []repo{renamed, other}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{c.repoDir(renamed), c.metaDir(renamed), c.repoDir(other)} {
+		if ok, _ := exists(d); !ok {
+			t.Errorf("expected %s to exist", d)
+		}
+	}
+	if ok, _ := exists(c.repoDir(old)); ok {
+		t.Errorf("expected %s to be moved", c.repoDir(old))
+	}
+	key, err := getRepoID(ctx, c.repoDir(renamed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "42" {
+		t.Errorf("expected ID 42; got %q", key)
+	}
+	if key, err := getRepoID(ctx, filepath.Join(dir, "missing.git")); err == nil {
+		t.Errorf("expected error for missing mirror; got %q", key)
+	}
+}
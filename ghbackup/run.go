@@ -1,15 +1,30 @@
 package ghbackup
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Run update for the given Config.
-func Run(config Config) error {
+// The returned Result contains the outcome for each repository,
+// also when an error is returned.
+func Run(config Config) (Result, error) {
+	return RunContext(context.Background(), config)
+}
+
+// RunContext is like Run but stops when the context is canceled.
+// No further repositories are started after cancellation
+// and running API requests are aborted.
+// Running git commands are finished to not leave lock files in the mirrors.
+// The Result then only contains the repositories that have been started.
+func RunContext(ctx context.Context, config Config) (Result, error) {
 	// Defaults
 	if config.Log == nil {
 		config.Log = log.New(ioutil.Discard, "", 0)
@@ -21,86 +36,328 @@ func Run(config Config) error {
 		config.Workers = defaultMaxWorkers
 	}
 	if config.API == "" {
-		config.API = defaultAPI
+		config.API = config.provider(Account{}).defaultAPI()
 	}
 	if config.Doer == nil {
 		config.Doer = http.DefaultClient
+		if config.CAFile != "" {
+			client, err := newCAClient(config.CAFile)
+			if err != nil {
+				return Result{}, err
+			}
+			config.Doer = client
+		}
+	}
+	config.Doer = newRateLimitDoer(config.Doer, config.Log)
+	if config.Retries == nil {
+		config.Retries = defaultRetries
+	}
+	config.Accounts = config.accountList()
+
+	var result Result
+
+	if config.Protocol != "" && config.Protocol != "https" && config.Protocol != "ssh" {
+		return result, fmt.Errorf("unsupported protocol %s", config.Protocol)
+	}
+	if config.App != nil && config.provider(Account{}) != GitHub {
+		return result, fmt.Errorf("a GitHub App cannot be used with provider %s", config.provider(Account{}))
+	}
+	if !validOrphanPolicy(config.Orphans) {
+		return result, fmt.Errorf("unsupported orphan policy %s", config.Orphans)
+	}
+	// A repository missing once, for example after a temporary permission change, must not delete its backup
+	if config.Orphans == OrphansDelete && config.OrphanDays <= 0 {
+		return result, fmt.Errorf("orphaned mirrors can only be deleted after at least one day")
 	}
 
+	if config.LFS {
+		if err := checkLFS(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	status, err := ReadStatus(config.Dir)
+	if err != nil {
+		return result, err
+	}
+	config.state = &runState{repos: status}
+
+	filter, err := config.Filter.compile()
+	if err != nil {
+		return result, err
+	}
+	starredFilter, err := config.StarredFilter.compile()
+	if err != nil {
+		return result, fmt.Errorf("invalid filter for starred repositories: %v", err)
+	}
+
+	// Requests listing repositories are cached between runs
+	cache, err := newCacheDoer(config.Doer, filepath.Join(config.Dir, cacheFile))
+	if err != nil {
+		return result, err
+	}
+	listing := config
+	listing.Doer = cache
+
 	// Fetch list of repositories
-	repos, err := fetch(config.Account, config.Secret, config.API, config.Doer)
+	repos, err := listing.fetchAccounts(ctx)
 	if err != nil {
-		return err
+		return result, err
+	}
+
+	repos, skipped := filter.apply(repos)
+	// Skipped repos still exist and are not orphaned
+	unlisted := skipped
+	if config.Wikis {
+		unlisted = withWikis(skipped)
+	}
+	for _, r := range skipped {
+		config.Log.Printf("Skipping %s (%s)", r.Path, filter.skipReason(r))
+		result.Repos = append(result.Repos, RepoResult{Name: config.repoName(r), State: StateSkipped})
+	}
+
+	if config.Wikis {
+		repos = withWikis(repos)
+	}
+
+	if config.Gists {
+		gists, err := listing.fetchGists(ctx)
+		if err != nil {
+			return result, err
+		}
+		repos = append(repos, gists...)
 	}
 
+	if config.Starred {
+		starred, err := listing.fetchStarred(ctx)
+		if err != nil {
+			return result, err
+		}
+		starred, skippedStarred := starredFilter.apply(starred)
+		for _, r := range skippedStarred {
+			s := config.starredRepo(r)
+			config.Log.Printf("Skipping %s (%s)", s.Path, starredFilter.skipReason(r))
+			result.Repos = append(result.Repos, RepoResult{Name: config.repoName(s), State: StateSkipped})
+			unlisted = append(unlisted, s)
+		}
+		for _, r := range starred {
+			repos = append(repos, config.starredRepo(r))
+		}
+	}
+
+	if err := cache.save(); err != nil {
+		return result, fmt.Errorf("cannot save API cache: %v", err)
+	}
+
+	listed := append(unlisted, repos...)
+	if err := config.moveRenamed(ctx, listed); err != nil {
+		return result, err
+	}
+	orphans, err := config.handleOrphans(listed, time.Now())
+	result.Repos = append(result.Repos, orphans...)
+	if err != nil {
+		return result, err
+	}
+
+	// Results so far are repositories skipped by filters and orphaned mirrors
+	filtered := len(result.Repos)
 	config.Log.Printf("%d repositories:", len(repos))
 
-	results := make(chan repoState)
+	results := make(chan RepoResult)
 
 	// Backup repositories in parallel with retries
-	go each(repos, config.Workers, func(r repo) {
-		state, err := config.backup(r)
-		for _, sleepDuration := range []time.Duration{5, 15, 45, 90, 180, -1} {
-			if err != nil {
-				if sleepDuration == -1 {
-					config.Log.Printf("repository %v failed to get cloned: %v", r, err)
-					break
-				}
-				config.Err.Println(err)
-				time.Sleep(sleepDuration * time.Second)
-				state, err = config.backup(r)
-				continue
-			}
+	go func() {
+		each(ctx, len(repos), config.Workers, func(i int) {
+			results <- config.backupWithRetries(ctx, repos[i])
+		})
+		close(results)
+	}()
+
+	for res := range results {
+		result.Repos = append(result.Repos, res)
+	}
+
+	config.Log.Print(result.summary())
+	if err := config.writeState(result, ctx.Err() == nil); err != nil {
+		return result, fmt.Errorf("cannot save state: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		started := len(result.Repos) - filtered
+		return result, fmt.Errorf("backup stopped after %d of %d repositories: %v", started, len(repos), err)
+	}
+	if failed := result.Count(StateFailed); failed > 0 {
+		return result, fmt.Errorf("failed to get %d repositories", failed)
+	}
+	return result, nil
+}
+
+// Add the wiki after each repository that has the wiki enabled.
+func withWikis(repos []repo) []repo {
+	var all []repo
+	for _, r := range repos {
+		all = append(all, r)
+		if r.HasWiki {
+			all = append(all, wikiRepo(r))
+		}
+	}
+	return all
+}
+
+// Get the wiki of a repository.
+// Wikis are separate git repositories next to the repository.
+func wikiRepo(r repo) repo {
+	w := r
+	w.Path = r.Path + ".wiki"
+	if r.URL != "" {
+		w.URL = strings.TrimSuffix(r.URL, ".git") + ".wiki.git"
+	}
+	if r.SSHURL != "" {
+		w.SSHURL = strings.TrimSuffix(r.SSHURL, ".git") + ".wiki.git"
+	}
+	if r.dir != "" {
+		w.dir = strings.TrimSuffix(r.dir, ".git") + ".wiki.git"
+	}
+	w.wiki = true
+	return w
+}
+
+// Combine Account and Accounts into a single list without duplicates.
+// Accounts with the same name on different providers or APIs are different accounts.
+func (c Config) accountList() []Account {
+	var list []Account
+	seen := map[string]bool{}
+	for _, a := range append([]Account{{Name: c.Account}}, c.Accounts...) {
+		key := c.provider(a).String() + " " + c.accountAPI(a) + " " + strings.ToLower(a.Name)
+		if a.Name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		list = append(list, a)
+	}
+	return list
+}
+
+// Backup a single repository and retry if it fails.
+func (c Config) backupWithRetries(ctx context.Context, r repo) RepoResult {
+	start := time.Now()
+	size := func() int64 {
+		return dirSize(c.repoDir(r)) + dirSize(c.metaDir(r))
+	}
+	sizeBefore := size()
+	lfsBefore := dirSize(c.lfsDir(r))
+
+	res := RepoResult{Name: c.repoName(r), Attempts: 1}
+	res.State, res.Err = c.backupAndExport(ctx, r)
+	for _, wait := range c.Retries {
+		if res.Err == nil {
 			break
 		}
-		results <- state
-	})
-
-	var creations, updates, unchanged, failed int
-
-	for i := 0; i < len(repos); i++ {
-		state := <-results
-		if state == stateNew {
-			creations++
-		} else if state == stateChanged {
-			updates++
-		} else if state == stateUnchanged {
-			unchanged++
-		} else {
-			failed++
+		c.Err.Println(res.Err)
+		if !sleep(ctx, wait) {
+			break
+		}
+		res.Attempts++
+		res.State, res.Err = c.backupAndExport(ctx, r)
+	}
+	if res.Err != nil {
+		c.Log.Printf("repository %s failed to get cloned: %v", r.Path, res.Err)
+	}
+
+	// A backup failing because the run has been stopped is not recorded as failure
+	stopped := res.Err != nil && ctx.Err() != nil
+	if c.state != nil && res.State != StateSkipped && !stopped {
+		var heads map[string]string
+		if res.Err == nil {
+			var err error
+			if heads, err = c.headRefs(detachedContext{ctx}, r); err != nil {
+				c.Err.Println(err)
+			}
 		}
+		c.state.update(r, res, heads, c.fetchesLFS(r), time.Now())
 	}
-	close(results)
 
-	config.Log.Printf(
-		"done: %d new, %d updated, %d unchanged",
-		creations,
-		updates,
-		unchanged,
-	)
-	if failed > 0 {
-		return fmt.Errorf("failed to get %d repositories", failed)
+	res.Duration = time.Since(start)
+	if grown := size() - sizeBefore; grown > 0 {
+		res.Bytes = grown
 	}
-	return nil
+	if grown := dirSize(c.lfsDir(r)) - lfsBefore; grown > 0 {
+		res.LFSBytes = grown
+	}
+	return res
 }
 
-func each(repos []repo, workers int, worker func(repo)) {
-	if len(repos) < workers {
-		workers = len(repos)
+// Backup a repository with its LFS objects and export its metadata.
+// The git step is skipped if the repository has not been pushed to since the last backup.
+func (c Config) backupAndExport(ctx context.Context, r repo) (State, error) {
+	unchanged, err := c.unchangedSincePush(r)
+	if err != nil {
+		return StateFailed, err
+	}
+	state := StateUnchanged
+	if unchanged {
+		c.Log.Printf("Unchanged %s (no push since last backup)", r.Path)
+	} else {
+		state, err = c.backup(ctx, r)
+		if err != nil || state == StateSkipped {
+			return state, err
+		}
+		if c.fetchesLFS(r) {
+			if err := c.fetchLFS(ctx, r); err != nil {
+				return StateFailed, err
+			}
+		}
 	}
+	if err := c.export(ctx, r); err != nil {
+		return StateFailed, err
+	}
+	return state, nil
+}
+
+// Wait for the given duration.
+// Returns false if the context has been canceled before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	jobs := make(chan repo)
+// Call worker for the indexes 0 to n-1 using the given number of goroutines.
+// Stops queueing jobs once the context is canceled.
+// Returns after all started workers finished.
+func each(ctx context.Context, n, workers int, worker func(int)) {
+	if n < workers {
+		workers = n
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
 
 	for w := 0; w < workers; w++ {
+		wg.Add(1)
 		go func() {
-			for r := range jobs {
-				worker(r)
+			defer wg.Done()
+			for i := range jobs {
+				worker(i)
 			}
 		}()
 	}
 
-	for _, r := range repos {
-		jobs <- r
+queue:
+	for i := 0; i < n; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break queue
+		}
 	}
 	close(jobs)
+	wg.Wait()
 }
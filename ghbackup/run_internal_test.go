@@ -0,0 +1,149 @@
+package ghbackup
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_each(t *testing.T) {
+	var mu sync.Mutex
+	var done []int
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	each(ctx, 10, 2, func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, i)
+		if len(done) == 3 {
+			cancel()
+		}
+	})
+	// Jobs already handed to a worker can still run after cancellation
+	if len(done) < 3 || len(done) > 5 {
+		t.Errorf("expected 3 to 5 jobs to run before stopping; got %v", done)
+	}
+
+	done = nil
+	each(context.Background(), 10, 3, func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, i)
+	})
+	if len(done) != 10 {
+		t.Errorf("expected all jobs to run; got %v", done)
+	}
+}
+
+func Test_sleep(t *testing.T) {
+	if !sleep(context.Background(), time.Millisecond) {
+		t.Errorf("expected sleep to finish")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if sleep(ctx, time.Hour) {
+		t.Errorf("expected canceled sleep to return false")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected canceled sleep to return immediately")
+	}
+}
+
+func Test_RunContext_orphanDays(t *testing.T) {
+	_, err := RunContext(context.Background(), Config{Account: "qvl", Dir: "/nonexistent", Orphans: OrphansDelete})
+	if err == nil || !strings.Contains(err.Error(), "at least one day") {
+		t.Errorf("expected deleting orphans without orphan days to be rejected; got %v", err)
+	}
+}
+
+func Test_withWikis(t *testing.T) {
+	repos := []repo{
+		{Path: "qvl/ghbackup", URL: "https://github.com/qvl/ghbackup.git", SSHURL: "git@github.com:qvl/ghbackup.git", HasWiki: true},
+		{Path: "qvl/sleepto"},
+		{Path: "team/sub/tool", URL: "https://gitlab.com/team/sub/tool.git", HasWiki: true, dir: "/backup/sub/tool.git"},
+	}
+	var got []repo
+	for _, r := range withWikis(repos) {
+		r.HasWiki = false
+		got = append(got, r)
+	}
+	want := []repo{
+		{Path: "qvl/ghbackup", URL: "https://github.com/qvl/ghbackup.git", SSHURL: "git@github.com:qvl/ghbackup.git"},
+		{Path: "qvl/ghbackup.wiki", URL: "https://github.com/qvl/ghbackup.wiki.git", SSHURL: "git@github.com:qvl/ghbackup.wiki.git", wiki: true},
+		{Path: "qvl/sleepto"},
+		{Path: "team/sub/tool", URL: "https://gitlab.com/team/sub/tool.git", dir: "/backup/sub/tool.git"},
+		{Path: "team/sub/tool.wiki", URL: "https://gitlab.com/team/sub/tool.wiki.git", dir: "/backup/sub/tool.wiki.git", wiki: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withWikis() = %+v, want %+v", got, want)
+	}
+
+	c := Config{Dir: "/backup", Accounts: []Account{{Name: "qvl"}}}
+	if dir := c.repoDir(wikiRepo(repos[0])); dir != filepath.Join("/backup", "ghbackup.wiki.git") {
+		t.Errorf("unexpected wiki dir %s", dir)
+	}
+}
+
+func Test_accountList(t *testing.T) {
+	const ghe = "https://ghe.example.com/api/v3"
+	c := Config{
+		Dir:      "/backup",
+		API:      defaultAPI,
+		Account:  "voiapp",
+		Accounts: []Account{{Name: "VoiApp"}, {Name: "voiapp", API: ghe}, {Name: "voiapp", API: ghe}, {Name: "voiapp", Provider: GitHub}},
+	}
+	got := c.accountList()
+	want := []Account{{Name: "voiapp"}, {Name: "voiapp", API: ghe}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("accountList() = %v, want %v", got, want)
+	}
+
+	// The same repository on both hosts gets its own mirror and state
+	c.Accounts = got
+	dotCom := repo{Path: "voiapp/api"}
+	enterprise := repo{Path: "voiapp/api", api: ghe}
+	if dir := c.repoDir(dotCom); dir != filepath.Join("/backup", "voiapp", "api.git") {
+		t.Errorf("unexpected dir %s", dir)
+	}
+	if dir := c.repoDir(enterprise); dir != filepath.Join("/backup", "ghe.example.com", "voiapp", "api.git") {
+		t.Errorf("unexpected dir %s", dir)
+	}
+	if name := c.repoName(enterprise); name != "ghe.example.com/voiapp/api" {
+		t.Errorf("unexpected name %s", name)
+	}
+	if dir := c.starredRepo(enterprise).dir; dir != filepath.Join("/backup", "starred", "ghe.example.com", "voiapp", "api.git") {
+		t.Errorf("unexpected starred dir %s", dir)
+	}
+}
+
+func Test_accountList_providers(t *testing.T) {
+	c := Config{
+		Dir:      "/backup",
+		API:      defaultAPI,
+		Accounts: []Account{{Name: "team"}, {Name: "team", Provider: GitLab}, {Name: "Team", Provider: GitLab, API: defaultGitLabAPI}},
+	}
+	got := c.accountList()
+	want := []Account{{Name: "team"}, {Name: "team", Provider: GitLab}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("accountList() = %v, want %v", got, want)
+	}
+
+	// Projects of GitLab are saved below the host to not collide with repos of GitHub
+	c.Accounts = got
+	gitHub := repo{Path: "team/app"}
+	gitLab := repo{Path: "team/app", api: defaultGitLabAPI, provider: GitLab}
+	if dir := c.repoDir(gitHub); dir != filepath.Join("/backup", "team", "app.git") {
+		t.Errorf("unexpected dir %s", dir)
+	}
+	if dir := c.repoDir(gitLab); dir != filepath.Join("/backup", "gitlab.com", "team", "app.git") {
+		t.Errorf("unexpected dir %s", dir)
+	}
+	if name := c.repoName(gitLab); name != "gitlab.com/team/app" {
+		t.Errorf("unexpected name %s", name)
+	}
+}
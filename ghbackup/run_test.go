@@ -17,6 +17,8 @@ import (
 const (
 	expectedRepos = " ghbackup homebrew-tap promplot qvl.io slangbrain.com sleepto "
 	gitFiles      = " HEAD branches config description hooks info objects packed-refs refs "
+	// Files written by ghbackup next to the mirrors
+	metaFiles = " api_cache.json state.json "
 )
 
 func TestRun(t *testing.T) {
@@ -31,7 +33,7 @@ func TestRun(t *testing.T) {
 	}()
 
 	var logs, errs bytes.Buffer
-	err = ghbackup.Run(ghbackup.Config{
+	result, err := ghbackup.Run(ghbackup.Config{
 		Account: "qvl",
 		Dir:     dir,
 		Secret:  os.Getenv("SECRET"),
@@ -54,27 +56,36 @@ func TestRun(t *testing.T) {
 	//   Cloning qvl/sleepto
 	//   Cloning qvl/promplot
 	//   Cloning qvl/homebrew-tap
-	//   done: 6 new, 0 updated, 0 unchanged
+	//   done: 6 new, 0 updated, 0 unchanged, 0 orphaned
 	lines := strings.Split(logs.String(), "\n")
 	countFirstLine, err := strconv.Atoi(strings.Split(lines[0], " ")[0])
 	if err != nil {
 		t.Errorf("Cannot parse repository count from first line of output: '%s'", lines[0])
 	}
-	if lines[countFirstLine+1] != fmt.Sprintf("done: %d new, 0 updated, 0 unchanged", countFirstLine) {
+	if lines[countFirstLine+1] != fmt.Sprintf("done: %d new, 0 updated, 0 unchanged, 0 orphaned", countFirstLine) {
 		t.Errorf("Last line contains unexpected status information: '%s'", lines[countFirstLine+1])
 	}
+	if len(result.Repos) != countFirstLine || result.Count(ghbackup.StateNew) != countFirstLine {
+		t.Errorf("Expected result to contain %d new repositories; got %v", countFirstLine, result.Repos)
+	}
 
 	// Check contents of backup directory
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		t.Error(err)
 	}
+	var repoDirs []os.FileInfo
+	for _, f := range files {
+		if !strings.Contains(metaFiles, " "+f.Name()+" ") {
+			repoDirs = append(repoDirs, f)
+		}
+	}
 	minRepos := len(strings.Split(strings.TrimSpace(expectedRepos), " "))
-	if len(files) < minRepos {
-		t.Errorf("Expected to fetch at least %d repositories; got %d", minRepos, len(files))
+	if len(repoDirs) < minRepos {
+		t.Errorf("Expected to fetch at least %d repositories; got %d", minRepos, len(repoDirs))
 	}
 
-	for _, f := range files {
+	for _, f := range repoDirs {
 		if !f.IsDir() {
 			t.Errorf("Expected %s to be a directory", f.Name())
 		}
@@ -0,0 +1,67 @@
+package ghbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Starred repositories are saved in this sub-directory of Config.Dir as OWNER/REPO.git.
+const starredDir = "starred"
+
+// Get the starred repositories of all accounts.
+// Without accounts, the repositories starred by the authenticated user are returned.
+// Repositories starred by multiple accounts are only returned once.
+// Accounts not on GitHub are skipped.
+func (c Config) fetchStarred(ctx context.Context) ([]repo, error) {
+	if c.App != nil {
+		return nil, errors.New("starred repositories cannot be backed up with a GitHub App")
+	}
+
+	var all []repo
+	seen := map[string]bool{}
+	add := func(repos []repo) {
+		for _, r := range repos {
+			if !seen[r.Path] {
+				seen[r.Path] = true
+				all = append(all, r)
+			}
+		}
+	}
+
+	if len(c.Accounts) == 0 && c.provider(Account{}) == GitHub {
+		repos, err := fetchURL(ctx, c.API+"/user/starred?per_page=100", secretAuth{secret: c.Secret}, c.Doer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get starred repos: %v", err)
+		}
+		add(repos)
+	}
+	for _, account := range c.Accounts {
+		if c.provider(account) != GitHub {
+			continue
+		}
+		secret := c.accountSecret(account)
+		auth := secretAuth{account: account.Name, secret: secret}
+		repos, err := fetchURL(ctx, c.accountAPI(account)+"/users/"+account.Name+"/starred?per_page=100", auth, c.Doer)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get starred repos of %s: %v", account.Name, err)
+		}
+		for i := range repos {
+			repos[i].api = account.API
+		}
+		add(repos)
+	}
+	return all, nil
+}
+
+// Get a starred repository to back up in the starred directory.
+// Its name is prefixed to not be confused with a repository of the accounts.
+// Repositories of accounts not on Config.API are saved in a sub-directory named after their host.
+func (c Config) starredRepo(r repo) repo {
+	s := r
+	s.Path = starredDir + "/" + r.Path
+	s.dir = filepath.Join(c.Dir, starredDir, c.repoHost(r), filepath.FromSlash(r.Path)+".git")
+	s.starred = true
+	return s
+}
@@ -0,0 +1,49 @@
+package ghbackup
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Config_fetchStarred(t *testing.T) {
+	c := Config{
+		Dir:      "/backup",
+		API:      "https://api.github.com",
+		Accounts: []Account{{Name: "qvl"}, {Name: "jorinvo"}},
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Path {
+			case "/users/qvl/starred":
+				if req.URL.Query().Get("page") == "2" {
+					return jsonResponse(req, 200, `[{"full_name":"golang/go"}]`), nil
+				}
+				res := jsonResponse(req, 200, `[{"full_name":"qvl/sleepto"}]`)
+				res.Header.Set("Link", `<https://api.github.com/users/qvl/starred?per_page=100&page=2>; rel="next"`)
+				return res, nil
+			case "/users/jorinvo/starred":
+				return jsonResponse(req, 200, `[{"full_name":"golang/go"},{"full_name":"other/tool"}]`), nil
+			}
+			t.Errorf("unexpected request to %s", req.URL)
+			return jsonResponse(req, 404, ""), nil
+		}),
+	}
+
+	starred, err := c.fetchStarred(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths, dirs []string
+	for _, r := range starred {
+		s := c.starredRepo(r)
+		paths = append(paths, s.Path)
+		dirs = append(dirs, c.repoDir(s))
+	}
+	if want := []string{"starred/qvl/sleepto", "starred/golang/go", "starred/other/tool"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	if want := filepath.Join("/backup", "starred", "golang", "go.git"); dirs[1] != want {
+		t.Errorf("dir = %s, want %s", dirs[1], want)
+	}
+}
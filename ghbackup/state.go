@@ -0,0 +1,137 @@
+package ghbackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File in Config.Dir storing the state of all repositories between runs.
+const stateFile = "state.json"
+
+// RepoStatus is the state of a repository saved between runs.
+type RepoStatus struct {
+	// Time of the last successful backup
+	LastSuccess time.Time `json:"last_success"`
+	// Time of the last push to the repository as reported by GitHub at the last successful backup
+	PushedAt time.Time `json:"pushed_at"`
+	// SHAs of the branches by ref name at the last successful backup
+	Heads map[string]string `json:"heads,omitempty"`
+	// Reports if LFS objects were fetched at the last successful backup
+	LFS bool `json:"lfs,omitempty"`
+	// Number of runs the backup failed since the last successful backup
+	Failures    int       `json:"failures,omitempty"`
+	LastFailure time.Time `json:"last_failure"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// ReadStatus returns the state of all repositories backed up to dir by name.
+// Returns an empty map if no backup has been run yet.
+func ReadStatus(dir string) (map[string]RepoStatus, error) {
+	repos := map[string]RepoStatus{}
+	b, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return repos, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &repos); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", stateFile, err)
+	}
+	return repos, nil
+}
+
+// State of all repositories updated during a run.
+type runState struct {
+	mu    sync.Mutex
+	repos map[string]RepoStatus
+}
+
+// Get the state of a repository from the last run.
+func (s *runState) get(name string) (RepoStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.repos[name]
+	return st, ok
+}
+
+// Update the state of a repository after its backup.
+// lfs reports if the LFS objects of the repository are fetched.
+func (s *runState) update(r repo, res RepoResult, heads map[string]string, lfs bool, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.repos[res.Name]
+	if res.Err != nil {
+		st.Failures++
+		st.LastFailure = now
+		st.LastError = res.Err.Error()
+	} else {
+		st = RepoStatus{LastSuccess: now, PushedAt: r.PushedAt, Heads: heads, LFS: lfs}
+	}
+	s.repos[res.Name] = st
+}
+
+// Check if a repo has not been pushed to since its last successful backup.
+// Wikis, gists and GitLab projects are always updated since pushes to them are not reported.
+// Repos are also updated if their LFS objects have not been fetched before.
+func (c Config) unchangedSincePush(r repo) (bool, error) {
+	if c.Force || c.state == nil || r.wiki || r.PushedAt.IsZero() {
+		return false, nil
+	}
+	st, ok := c.state.get(c.repoName(r))
+	if !ok || st.LastSuccess.IsZero() || st.Failures > 0 || !st.PushedAt.Equal(r.PushedAt) {
+		return false, nil
+	}
+	if c.fetchesLFS(r) && !st.LFS {
+		return false, nil
+	}
+	// The mirror might have been removed since
+	return exists(c.repoDir(r))
+}
+
+// Save the state to Dir.
+// If the run completed, repositories that are not part of the result anymore are removed.
+func (c Config) writeState(result Result, complete bool) error {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if complete {
+		names := map[string]bool{}
+		for _, r := range result.Repos {
+			names[r.Name] = true
+		}
+		for name := range c.state.repos {
+			if !names[name] {
+				delete(c.state.repos, name)
+			}
+		}
+	}
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(c.Dir, stateFile), c.state.repos)
+}
+
+// Get the SHAs of all branches of a mirror by ref name.
+func (c Config) headRefs(ctx context.Context, r repo) (map[string]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "for-each-ref", "--format=%(refname) %(objectname)", "refs/heads")
+	cmd.Dir = c.repoDir(r)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get branches of %s: %v", r.Path, err)
+	}
+	heads := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if parts := strings.Fields(line); len(parts) == 2 {
+			heads[parts[0]] = parts[1]
+		}
+	}
+	return heads, nil
+}
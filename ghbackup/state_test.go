@@ -0,0 +1,101 @@
+package ghbackup
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Config_writeState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	pushed := time.Date(2020, 1, 9, 0, 0, 0, 0, time.UTC)
+	heads := map[string]string{"refs/heads/master": "abc"}
+	c := Config{Dir: dir, state: &runState{repos: map[string]RepoStatus{
+		"qvl/failing": {LastSuccess: pushed, Failures: 1},
+		"qvl/deleted": {LastSuccess: pushed},
+	}}}
+	ok := repo{Path: "qvl/ok", PushedAt: pushed}
+	c.state.update(ok, RepoResult{Name: "qvl/ok"}, heads, true, now)
+	c.state.update(repo{Path: "qvl/failing"}, RepoResult{Name: "qvl/failing", Err: errors.New("timeout")}, nil, false, now)
+
+	result := Result{Repos: []RepoResult{{Name: "qvl/ok"}, {Name: "qvl/failing"}}}
+	if err := c.writeState(result, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]RepoStatus{
+		"qvl/ok":      {LastSuccess: now, PushedAt: pushed, Heads: heads, LFS: true},
+		"qvl/failing": {LastSuccess: pushed, Failures: 2, LastFailure: now, LastError: "timeout"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("state = %v, want %v", got, want)
+	}
+}
+
+func Test_Config_unchangedSincePush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ghbackup-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	for _, name := range []string{"ghbackup.git", "lfs.git"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pushed := time.Date(2020, 1, 9, 0, 0, 0, 0, time.UTC)
+	state := &runState{repos: map[string]RepoStatus{
+		"qvl/ghbackup":      {LastSuccess: pushed, PushedAt: pushed},
+		"qvl/ghbackup.wiki": {LastSuccess: pushed, PushedAt: pushed},
+		"qvl/failing":       {LastSuccess: pushed, PushedAt: pushed, Failures: 1},
+		"qvl/removed":       {LastSuccess: pushed, PushedAt: pushed},
+		"qvl/lfs":           {LastSuccess: pushed, PushedAt: pushed, LFS: true},
+	}}
+	tests := []struct {
+		name  string
+		force bool
+		lfs   bool
+		r     repo
+		want  bool
+	}{
+		{"unchanged", false, false, repo{Path: "qvl/ghbackup", PushedAt: pushed}, true},
+		{"force", true, false, repo{Path: "qvl/ghbackup", PushedAt: pushed}, false},
+		{"pushed", false, false, repo{Path: "qvl/ghbackup", PushedAt: pushed.Add(time.Hour)}, false},
+		{"wiki", false, false, repo{Path: "qvl/ghbackup.wiki", PushedAt: pushed, wiki: true}, false},
+		{"failing", false, false, repo{Path: "qvl/failing", PushedAt: pushed}, false},
+		{"mirror removed", false, false, repo{Path: "qvl/removed", PushedAt: pushed}, false},
+		{"new", false, false, repo{Path: "qvl/new", PushedAt: pushed}, false},
+		{"LFS enabled", false, true, repo{Path: "qvl/ghbackup", PushedAt: pushed}, false},
+		{"LFS fetched", false, true, repo{Path: "qvl/lfs", PushedAt: pushed}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Config{Dir: dir, Accounts: []Account{{Name: "qvl"}}, Force: tt.force, LFS: tt.lfs, state: state}
+			got, err := c.unchangedSincePush(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("unchangedSincePush() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
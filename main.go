@@ -3,12 +3,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"qvl.io/ghbackup/ghbackup"
 )
@@ -23,26 +28,277 @@ const (
 Usage: %s [flags] directory
 
   directory  path to save the repositories to
+             can be omitted if set in the -config file
 
-At least one of -account or -secret must be specified.
+At least one of -account, -secret or -app-id must be specified.
 
 Flags:
 `
 	more         = "\nFor more visit https://qvl.io/ghbackup."
-	accountUsage = `GitHub user or organization name to get repositories from.
+	accountUsage = "GitHub user or organization or GitLab user or group `name` to get repositories from." + `
+	Multiple accounts can be separated by commas or the flag can be repeated.
+	With multiple accounts, repositories are saved to a sub-directory per owner.
 	If not specified, all repositories the authenticated user has access to will be loaded.`
 	secretUsage = `Authentication secret for GitHub API.
 	Can use the users password or a personal access token (https://github.com/settings/tokens).
+	For GitLab use a personal or group access token with the read_api and read_repository scopes.
 	Authentication increases rate limiting (https://developer.github.com/v3/#rate-limiting) and enables backup of private repositories.`
+	includeUsage = "Only backup repositories matching the `pattern`. Can be repeated." + `
+	Patterns are matched against the full name (owner/repo) and the repository name.
+	Globs like "platform-*" and regular expressions in slashes like "/^platform-/" are supported.`
+	excludeUsage = "Skip repositories matching the `pattern`. Can be repeated." + `
+	Supports the same patterns as -include.`
+	configUsage = "Read configuration from a YAML `file`." + `
+	Supports all flags except -silent, -status, -stale and -version and additionally per-account secrets, providers and API URLs, workers, retries and filters for starred repositories.
+	Flags override values of the file.
+	For an example see https://qvl.io/ghbackup.`
+	appIDUsage = "`ID` of a GitHub App to authenticate as instead of using -secret." + `
+	Requires -app-key. Backs up all installations of the app on the given accounts.`
+	appKeyUsage          = "Private key `file` of the GitHub App in PEM format."
+	appInstallationUsage = "`ID` of the installation of the GitHub App to back up. Defaults to all installations."
+	protocolUsage        = "The `protocol` used to clone repositories: https or ssh." + `
+	With ssh, the secret is only used for the GitHub API and git authenticates with an SSH key.`
+	sshKeyUsage        = "Private key `file` used with -protocol ssh. Defaults to the keys loaded by ssh."
+	sshKnownHostsUsage = "known_hosts `file` used with -protocol ssh. Unknown hosts are rejected."
+	topicUsage         = "Only backup repositories with the `topic`. Can be repeated to allow any of multiple topics."
+	languageUsage      = "Only backup repositories with the primary `language`. Can be repeated to allow any of multiple languages."
+	maxSizeUsage       = "Skip repositories larger than the given number of `kilobytes` as reported by GitHub. 0 means no limit."
+	starredUsage       = "Also backup repositories starred by the accounts to DIR/starred/OWNER/REPO.git." + `
+	They are not affected by the other filters; use -starred-max-size or starred_filter in the -config file instead.`
+	orphansUsage = "What to do with mirrors of repositories no longer listed by GitHub: keep, archive or delete." + `
+	With archive, they are moved to DIR/_archive/DATE. With delete, they are deleted after -orphan-days.
+	Orphaned mirrors are always reported.`
+	orphanDaysUsage     = "Number of `days` orphaned mirrors are kept with -orphans delete. Must be at least 1 then."
+	starredMaxSizeUsage = "Skip starred repositories larger than the given number of `kilobytes`. 0 means no limit."
+	statusUsage         = "Print repositories that failed or are stale according to the state saved by previous runs and exit." + `
+	Exits with status 1 if there are any.`
+	apiUsage = "`URL` of the API of the provider. Defaults to https://api.github.com or https://gitlab.com/api/v4." + `
+	For GitHub Enterprise Server use https://HOST/api/v3, for self-managed GitLab https://HOST/api/v4.`
 )
 
+// Flag that can be specified multiple times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// Split all comma separated values
+func splitList(l listFlag) []string {
+	var values []string
+	for _, v := range l {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// Values of all command line flags
+type flags struct {
+	configFile      string
+	accounts        listFlag
+	secret          string
+	versionFlag     bool
+	silent          bool
+	status          bool
+	stale           time.Duration
+	appID           int64
+	appKey          string
+	appInstallation int64
+	provider        string
+	api             string
+	caFile          string
+	graphQL         bool
+	protocol        string
+	sshKey          string
+	sshKnownHosts   string
+	wikis           bool
+	issues          bool
+	pulls           bool
+	gists           bool
+	starred         bool
+	lfs             bool
+	releases        bool
+	orphans         string
+	orphanDays      int
+	force           bool
+	include         listFlag
+	exclude         listFlag
+	skipForks       bool
+	skipArchived    bool
+	skipDisabled    bool
+	onlyPrivate     bool
+	topics          listFlag
+	languages       listFlag
+	maxSize         int
+	starredMaxSize  int
+}
+
+// Define all flags on a flag set.
+func defineFlags(fs *flag.FlagSet) *flags {
+	f := &flags{}
+	fs.StringVar(&f.configFile, "config", "", configUsage)
+	fs.Var(&f.accounts, "account", accountUsage)
+	fs.StringVar(&f.secret, "secret", "", secretUsage)
+	fs.BoolVar(&f.versionFlag, "version", false, "Print binary version")
+	fs.BoolVar(&f.silent, "silent", false, "Suppress all output")
+	fs.BoolVar(&f.status, "status", false, statusUsage)
+	fs.DurationVar(&f.stale, "stale", 7*24*time.Hour, "Report repositories without successful backup for this `duration` with -status. 0 disables it.")
+	fs.Int64Var(&f.appID, "app-id", 0, appIDUsage)
+	fs.StringVar(&f.appKey, "app-key", "", appKeyUsage)
+	fs.Int64Var(&f.appInstallation, "app-installation", 0, appInstallationUsage)
+	fs.StringVar(&f.provider, "provider", "github", "Get repositories from the provider with the given `name`: github or gitlab.")
+	fs.StringVar(&f.api, "api", "", apiUsage)
+	fs.StringVar(&f.caFile, "ca-file", "", "PEM `file` with additional CA certificates, for example for GitHub Enterprise Server with a self-signed certificate")
+	fs.BoolVar(&f.graphQL, "graphql", false, "List repositories with the GraphQL API instead of the REST API. Requires -secret.")
+	fs.StringVar(&f.protocol, "protocol", "https", protocolUsage)
+	fs.StringVar(&f.sshKey, "ssh-key", "", sshKeyUsage)
+	fs.StringVar(&f.sshKnownHosts, "ssh-known-hosts", "", sshKnownHostsUsage)
+	fs.BoolVar(&f.wikis, "wikis", false, "Also backup the wiki of each repository as REPO.wiki.git")
+	fs.BoolVar(&f.issues, "issues", false, "Also export issues, comments, labels and milestones as JSON to REPO.meta")
+	fs.BoolVar(&f.pulls, "pulls", false, "Also export pull requests with review comments, reviews and timelines as JSON to REPO.meta")
+	fs.BoolVar(&f.gists, "gists", false, "Also backup gists of the accounts to DIR/gists/ID.git with an index in DIR/gists/index.json")
+	fs.BoolVar(&f.starred, "starred", false, starredUsage)
+	fs.BoolVar(&f.lfs, "lfs", false, "Also fetch Git LFS objects of all refs; requires git-lfs")
+	fs.BoolVar(&f.releases, "releases", false, "Also export releases as JSON and download their assets to REPO.meta/releases")
+	fs.StringVar(&f.orphans, "orphans", "keep", orphansUsage)
+	fs.IntVar(&f.orphanDays, "orphan-days", 0, orphanDaysUsage)
+	fs.BoolVar(&f.force, "force", false, "Update all repositories, also the ones that have not been pushed to since the last backup")
+	fs.Var(&f.include, "include", includeUsage)
+	fs.Var(&f.exclude, "exclude", excludeUsage)
+	fs.BoolVar(&f.skipForks, "skip-forks", false, "Skip forked repositories")
+	fs.BoolVar(&f.skipArchived, "skip-archived", false, "Skip archived repositories")
+	fs.BoolVar(&f.skipDisabled, "skip-disabled", false, "Skip disabled repositories")
+	fs.BoolVar(&f.onlyPrivate, "only-private", false, "Skip public repositories")
+	fs.Var(&f.topics, "topic", topicUsage)
+	fs.Var(&f.languages, "language", languageUsage)
+	fs.IntVar(&f.maxSize, "max-size", 0, maxSizeUsage)
+	fs.IntVar(&f.starredMaxSize, "starred-max-size", 0, starredMaxSizeUsage)
+	return f
+}
+
+// Override values of a config with the flags that have been set.
+// Flags that have not been set keep the values of the config file.
+func (f *flags) apply(config *ghbackup.Config, fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+	if set["account"] {
+		config.Accounts = nil
+		for _, name := range splitList(f.accounts) {
+			config.Accounts = append(config.Accounts, ghbackup.Account{Name: name})
+		}
+	}
+	if set["secret"] {
+		config.Secret = f.secret
+	}
+	if set["app-id"] || set["app-key"] || set["app-installation"] {
+		app, err := readApp(f.appID, f.appKey, f.appInstallation)
+		if err != nil {
+			return fmt.Errorf("invalid GitHub App: %v", err)
+		}
+		config.App = app
+	}
+	if set["provider"] {
+		p, err := ghbackup.ParseProvider(f.provider)
+		if err != nil {
+			return err
+		}
+		config.Provider = p
+	}
+	if set["api"] {
+		config.API = f.api
+	}
+	if set["ca-file"] {
+		config.CAFile = f.caFile
+	}
+	if set["graphql"] {
+		config.GraphQL = f.graphQL
+	}
+	if set["protocol"] {
+		config.Protocol = f.protocol
+	}
+	if set["ssh-key"] {
+		config.SSHKey = f.sshKey
+	}
+	if set["ssh-known-hosts"] {
+		config.SSHKnownHosts = f.sshKnownHosts
+	}
+	if set["wikis"] {
+		config.Wikis = f.wikis
+	}
+	if set["issues"] {
+		config.Issues = f.issues
+	}
+	if set["pulls"] {
+		config.Pulls = f.pulls
+	}
+	if set["gists"] {
+		config.Gists = f.gists
+	}
+	if set["starred"] {
+		config.Starred = f.starred
+	}
+	if set["lfs"] {
+		config.LFS = f.lfs
+	}
+	if set["releases"] {
+		config.Releases = f.releases
+	}
+	if set["orphans"] {
+		config.Orphans = f.orphans
+	}
+	if set["orphan-days"] {
+		config.OrphanDays = f.orphanDays
+	}
+	if set["force"] {
+		config.Force = f.force
+	}
+	if set["include"] {
+		config.Include = f.include
+	}
+	if set["exclude"] {
+		config.Exclude = f.exclude
+	}
+	if set["skip-forks"] {
+		config.SkipForks = f.skipForks
+	}
+	if set["skip-archived"] {
+		config.SkipArchived = f.skipArchived
+	}
+	if set["skip-disabled"] {
+		config.SkipDisabled = f.skipDisabled
+	}
+	if set["only-private"] {
+		config.OnlyPrivate = f.onlyPrivate
+	}
+	if set["topic"] {
+		config.Topics = f.topics
+	}
+	if set["language"] {
+		config.Languages = f.languages
+	}
+	if set["max-size"] {
+		config.MaxSize = f.maxSize
+	}
+	if set["starred-max-size"] {
+		config.StarredFilter.MaxSize = f.starredMaxSize
+	}
+	return nil
+}
+
 // Get command line arguments and start updating repositories
 func main() {
-	// Flags
-	account := flag.String("account", "", accountUsage)
-	secret := flag.String("secret", "", secretUsage)
-	versionFlag := flag.Bool("version", false, "Print binary version")
-	silent := flag.Bool("silent", false, "Suppress all output")
+	f := defineFlags(flag.CommandLine)
 
 	// Parse args
 	flag.Usage = func() {
@@ -52,29 +308,72 @@ func main() {
 	}
 	flag.Parse()
 
-	if *versionFlag {
+	if f.versionFlag {
 		fmt.Printf("ghbackup %s %s %s\n", version, runtime.GOOS, runtime.GOARCH)
 		os.Exit(0)
 	}
 
+	var config ghbackup.Config
+	if f.configFile != "" {
+		var err error
+		config, err = readConfig(f.configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// Flags override values from the config file
+	if err := f.apply(&config, flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	args := flag.Args()
-	if len(args) != 1 || (*account == "" && *secret == "") {
+	if len(args) == 1 {
+		config.Dir = args[0]
+	}
+	if f.status {
+		if len(args) > 1 || config.Dir == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		repos, err := ghbackup.ReadStatus(config.Dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if printStatus(os.Stdout, repos, f.stale, time.Now()) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(args) > 1 || config.Dir == "" || (len(config.Accounts) == 0 && config.Secret == "" && config.App == nil) {
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	logger := log.New(os.Stdout, "", 0)
-	if *silent {
+	if f.silent {
 		logger = log.New(ioutil.Discard, "", 0)
 	}
+	config.Log = logger
+	config.Err = log.New(os.Stderr, "", 0)
 
-	err := ghbackup.Run(ghbackup.Config{
-		Account: *account,
-		Dir:     args[0],
-		Secret:  *secret,
-		Log:     logger,
-		Err:     log.New(os.Stderr, "", 0),
-	})
+	// Stop gracefully on the first signal.
+	// A second signal uses the default behavior and exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		fmt.Fprintf(os.Stderr, "received %v, stopping backup\n", sig)
+		cancel()
+	}()
+
+	_, err := ghbackup.RunContext(ctx, config)
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
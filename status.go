@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"qvl.io/ghbackup/ghbackup"
+)
+
+// Print repositories that are failing or have not been backed up successfully within stale.
+// Returns the number of repositories listed.
+func printStatus(w io.Writer, repos map[string]ghbackup.RepoStatus, stale time.Duration, now time.Time) int {
+	var names []string
+	for name := range repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := 0
+	for _, name := range names {
+		s := repos[name]
+		isStale := stale > 0 && now.Sub(s.LastSuccess) > stale
+		if s.Failures == 0 && !isStale {
+			continue
+		}
+		problems++
+		last := "never"
+		if !s.LastSuccess.IsZero() {
+			last = s.LastSuccess.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s: last backup %s", name, last)
+		if s.Failures > 0 {
+			fmt.Fprintf(w, ", failed %d times in a row: %s", s.Failures, s.LastError)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d repositories, %d failing or stale\n", len(repos), problems)
+	return problems
+}